@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// aliases ... bucket name to the alias shown in reports, loaded from -alias-file
+var aliases = map[string]string{}
+
+// loadAliases ... aliases from a json file of bucket name to alias, e.g. {"company-prod-a1b2c3": "prod assets"}
+func loadAliases(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read alias file: %v", err)
+	}
+	loaded := map[string]string{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		return nil, fmt.Errorf("unable to parse alias file %s: %v", path, err)
+	}
+	return loaded, nil
+}
+
+// displayName ... alias of bucket if it has one, otherwise its name
+func displayName(bucket Bucket) string {
+	if bucket.Alias != "" {
+		return bucket.Alias
+	}
+	return bucket.Name
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// cloudwatchAPI ... subset of cloudwatch client used for scanning
+type cloudwatchAPI interface {
+	GetMetricStatisticsWithContext(aws.Context, *cloudwatch.GetMetricStatisticsInput, ...request.Option) (*cloudwatch.GetMetricStatisticsOutput, error)
+	GetMetricDataPagesWithContext(aws.Context, *cloudwatch.GetMetricDataInput, func(*cloudwatch.GetMetricDataOutput, bool) bool, ...request.Option) error
+	ListMetricsPagesWithContext(aws.Context, *cloudwatch.ListMetricsInput, func(*cloudwatch.ListMetricsOutput, bool) bool, ...request.Option) error
+}
+
+// s3API ... subset of s3 client used for listing buckets and reading their configuration
+type s3API interface {
+	ListBuckets(*s3.ListBucketsInput) (*s3.ListBucketsOutput, error)
+	GetBucketLifecycleConfiguration(*s3.GetBucketLifecycleConfigurationInput) (*s3.GetBucketLifecycleConfigurationOutput, error)
+	GetBucketAcl(*s3.GetBucketAclInput) (*s3.GetBucketAclOutput, error)
+	GetBucketReplication(*s3.GetBucketReplicationInput) (*s3.GetBucketReplicationOutput, error)
+	GetBucketTagging(*s3.GetBucketTaggingInput) (*s3.GetBucketTaggingOutput, error)
+	GetBucketRequestPayment(*s3.GetBucketRequestPaymentInput) (*s3.GetBucketRequestPaymentOutput, error)
+	GetBucketVersioning(*s3.GetBucketVersioningInput) (*s3.GetBucketVersioningOutput, error)
+	GetBucketLocation(*s3.GetBucketLocationInput) (*s3.GetBucketLocationOutput, error)
+}
+
+// clientProvider ... returns regional clients used for scanning
+type clientProvider interface {
+	regionAPI
+	CloudWatch(region string) cloudwatchAPI
+	S3(region string) s3API
+}
+
+// regionAPI ... resolves the region a bucket lives in
+type regionAPI interface {
+	BucketRegion(bucketName string) (string, error)
+}
+
+// awsClients ... clientProvider and regionAPI of one credential profile
+// clients are cached per region
+type awsClients struct {
+	profile string
+	region  string
+	sess    client.ConfigProvider
+	config  aws.Config
+
+	// concurrency ... -concurrency-auto controller fed by cloudwatch attempts, set before scanning
+	concurrency *aimd
+
+	mu        sync.Mutex
+	cwClients map[string]*cloudwatch.CloudWatch
+	s3Clients map[string]*s3.S3
+}
+
+// newAWSClients ... clients of a credential profile, or of an -org account id through its assumed role
+func newAWSClients(profile string) *awsClients {
+	c := &awsClients{
+		profile: profile,
+		region:  profileRegion(profile),
+		sess:    session.Must(session.NewSession()),
+		config: aws.Config{
+			Credentials: credentials.NewSharedCredentials(credsFile, profile),
+		},
+		cwClients: map[string]*cloudwatch.CloudWatch{},
+		s3Clients: map[string]*s3.S3{},
+	}
+	if creds, ok := orgCredentials(profile, c.sess); ok {
+		c.region = orgRegion
+		c.config.Credentials = creds
+	}
+	return c
+}
+
+// CloudWatch ... return cached cloudwatch client for region, counting its usage for -show-api-cost
+func (c *awsClients) CloudWatch(region string) cloudwatchAPI {
+	return countingCloudWatch{c.cloudWatchClient(region)}
+}
+
+func (c *awsClients) cloudWatchClient(region string) *cloudwatch.CloudWatch {
+	c.mu.Lock()
+	cwSvc, ok := c.cwClients[region]
+	c.mu.Unlock()
+	if ok {
+		return cwSvc
+	}
+
+	cwSvc = cloudwatch.New(c.sess, &c.config, regionConfig(region))
+	cwSvc.Handlers.CompleteAttempt.PushBack(func(r *request.Request) {
+		if c.concurrency != nil {
+			c.concurrency.observe(r)
+		}
+	})
+	cwSvc.Handlers.Complete.PushBack(recordCall)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.cwClients[region]; ok {
+		return cached
+	}
+	c.cwClients[region] = cwSvc
+	return cwSvc
+}
+
+// S3 ... return cached s3 client for region
+func (c *awsClients) S3(region string) s3API {
+	return c.s3Client(region)
+}
+
+func (c *awsClients) s3Client(region string) *s3.S3 {
+	c.mu.Lock()
+	s3Svc, ok := c.s3Clients[region]
+	c.mu.Unlock()
+	if ok {
+		return s3Svc
+	}
+
+	s3Svc = s3.New(c.sess, &c.config, regionConfig(region))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.s3Clients[region]; ok {
+		return cached
+	}
+	c.s3Clients[region] = s3Svc
+	return s3Svc
+}
+
+// BucketRegion ... resolve bucket region with s3manager in the partition of the profile's default region
+func (c *awsClients) BucketRegion(bucketName string) (string, error) {
+	return s3manager.GetBucketRegionWithClient(context.Background(), c.s3Client(c.region), bucketName)
+}
+
+// CallerIdentity ... account and arn the profile's credentials belong to, asked at the -sts-region endpoint if given
+func (c *awsClients) CallerIdentity() (*sts.GetCallerIdentityOutput, error) {
+	region := c.region
+	if stsRegion != "" {
+		region = stsRegion
+	}
+	svc := sts.New(c.sess, &c.config, aws.NewConfig().WithRegion(region).WithSTSRegionalEndpoint(endpoints.RegionalSTSEndpoint))
+	return svc.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+}
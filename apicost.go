@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// USD per 1,000 GetMetricStatistics or ListMetrics requests and per 1,000 metrics requested by GetMetricData
+const cloudwatchAPIPrice = 0.01
+
+// apiUsage ... cloudwatch usage of the run, updated atomically by all scans
+var apiUsage struct {
+	statisticsRequests int64
+	dataMetrics        int64
+	listRequests       int64
+}
+
+// countingCloudWatch ... cloudwatchAPI recording the charged usage of each call in apiUsage
+type countingCloudWatch struct {
+	cloudwatchAPI
+}
+
+func (c countingCloudWatch) GetMetricStatisticsWithContext(ctx aws.Context, input *cloudwatch.GetMetricStatisticsInput, opts ...request.Option) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	atomic.AddInt64(&apiUsage.statisticsRequests, 1)
+	return c.cloudwatchAPI.GetMetricStatisticsWithContext(ctx, input, opts...)
+}
+
+// GetMetricDataPagesWithContext ... metrics of input are counted again for every page requested
+func (c countingCloudWatch) GetMetricDataPagesWithContext(ctx aws.Context, input *cloudwatch.GetMetricDataInput, fn func(*cloudwatch.GetMetricDataOutput, bool) bool, opts ...request.Option) error {
+	metrics := int64(0)
+	for _, query := range input.MetricDataQueries {
+		if query.MetricStat != nil {
+			metrics++
+		}
+	}
+	return c.cloudwatchAPI.GetMetricDataPagesWithContext(ctx, input, func(page *cloudwatch.GetMetricDataOutput, lastPage bool) bool {
+		atomic.AddInt64(&apiUsage.dataMetrics, metrics)
+		return fn(page, lastPage)
+	}, opts...)
+}
+
+// ListMetricsPagesWithContext ... every page requested is counted as a request
+func (c countingCloudWatch) ListMetricsPagesWithContext(ctx aws.Context, input *cloudwatch.ListMetricsInput, fn func(*cloudwatch.ListMetricsOutput, bool) bool, opts ...request.Option) error {
+	return c.cloudwatchAPI.ListMetricsPagesWithContext(ctx, input, func(page *cloudwatch.ListMetricsOutput, lastPage bool) bool {
+		atomic.AddInt64(&apiUsage.listRequests, 1)
+		return fn(page, lastPage)
+	}, opts...)
+}
+
+// printAPICost ... cloudwatch requests and metrics of the run and their estimated charge
+func printAPICost() {
+	requests := atomic.LoadInt64(&apiUsage.statisticsRequests)
+	metrics := atomic.LoadInt64(&apiUsage.dataMetrics)
+	lists := atomic.LoadInt64(&apiUsage.listRequests)
+	cost := float64(requests+metrics+lists) / 1000 * cloudwatchAPIPrice
+	fmt.Fprintf(os.Stderr, "cloudwatch api usage: %d GetMetricStatistics requests, %d GetMetricData metrics, %d ListMetrics requests, about %s USD\n",
+		requests, metrics, lists, formatDecimal(cost, 4))
+}
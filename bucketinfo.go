@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// getLifecycleRules ... number of lifecycle rules, 0 if none and -1 if unknown
+func getLifecycleRules(s3Svc s3API, bucketName string) int {
+	resp, err := s3Svc.GetBucketLifecycleConfiguration(&s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchLifecycleConfiguration" {
+			return 0
+		}
+		fmt.Fprintf(os.Stderr, "unable to get bucket %s's lifecycle configuration: %v\n", bucketName, err)
+		return -1
+	}
+	return len(resp.Rules)
+}
+
+// lifecycleLabel ... display value of lifecycle rules
+func lifecycleLabel(rules int) string {
+	switch {
+	case rules < 0:
+		return "unknown"
+	case rules == 0:
+		return "none"
+	case rules == 1:
+		return "1 rule"
+	}
+	return fmt.Sprintf("%d rules", rules)
+}
+
+// getVersioning ... versioning status of bucket (Enabled, Suspended, Off or unknown)
+func getVersioning(s3Svc s3API, bucketName string) string {
+	resp, err := s3Svc.GetBucketVersioning(&s3.GetBucketVersioningInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to get bucket %s's versioning: %v\n", bucketName, err)
+		return "unknown"
+	}
+	if resp.Status == nil {
+		return "Off"
+	}
+	return *resp.Status
+}
+
+// getOwner ... canonical id of bucket owner, empty if unknown
+func getOwner(s3Svc s3API, bucketName string) string {
+	resp, err := s3Svc.GetBucketAcl(&s3.GetBucketAclInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to get bucket %s's owner: %v\n", bucketName, err)
+		return ""
+	}
+	if resp.Owner == nil || resp.Owner.ID == nil {
+		return ""
+	}
+	return *resp.Owner.ID
+}
+
+// getReplication ... CRR if an enabled rule replicates to another region, SRR if only to the same region,
+// none without replication configuration and unknown on error
+func getReplication(s3Svc s3API, ra regionAPI, bucket Bucket) string {
+	resp, err := s3Svc.GetBucketReplication(&s3.GetBucketReplicationInput{
+		Bucket: aws.String(bucket.Name),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "ReplicationConfigurationNotFoundError" {
+			return "none"
+		}
+		fmt.Fprintf(os.Stderr, "unable to get bucket %s's replication: %v\n", bucket.Name, err)
+		return "unknown"
+	}
+	if resp.ReplicationConfiguration == nil {
+		return "none"
+	}
+	status := "none"
+	for _, rule := range resp.ReplicationConfiguration.Rules {
+		if aws.StringValue(rule.Status) != s3.ReplicationRuleStatusEnabled || rule.Destination == nil {
+			continue
+		}
+		// destination is an arn like arn:aws:s3:::bucket without region
+		destName := aws.StringValue(rule.Destination.Bucket)
+		destName = destName[strings.LastIndex(destName, ":")+1:]
+		destRegion, err := ra.BucketRegion(destName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to find bucket %s's replication destination %s region: %v\n", bucket.Name, destName, err)
+			return "unknown"
+		}
+		if destRegion != bucket.Region {
+			return "CRR"
+		}
+		status = "SRR"
+	}
+	return status
+}
+
+// getPayer ... who pays for requests (BucketOwner or Requester), unknown on error
+func getPayer(s3Svc s3API, bucketName string) string {
+	resp, err := s3Svc.GetBucketRequestPayment(&s3.GetBucketRequestPaymentInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to get bucket %s's request payment: %v\n", bucketName, err)
+		return "unknown"
+	}
+	if resp.Payer == nil {
+		return s3.PayerBucketOwner
+	}
+	return *resp.Payer
+}
+
+// getTags ... all tags of bucket, empty if none and nil on error
+func getTags(s3Svc s3API, bucketName string) map[string]string {
+	resp, err := s3Svc.GetBucketTagging(&s3.GetBucketTaggingInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NoSuchTagSet" {
+			return map[string]string{}
+		}
+		fmt.Fprintf(os.Stderr, "unable to get bucket %s's tags: %v\n", bucketName, err)
+		return nil
+	}
+	tags := map[string]string{}
+	for _, tag := range resp.TagSet {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return tags
+}
+
+// getLocation ... region GetBucketLocation reports for bucketName, the empty constraint means us-east-1
+func getLocation(s3Svc s3API, bucketName string) (string, error) {
+	resp, err := s3Svc.GetBucketLocation(&s3.GetBucketLocationInput{
+		Bucket: aws.String(bucketName),
+	})
+	if err != nil {
+		return "", err
+	}
+	return s3.NormalizeBucketLocation(aws.StringValue(resp.LocationConstraint)), nil
+}
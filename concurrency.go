@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+const (
+	// throttled attempts since the last change that halve the concurrency
+	throttleThreshold = 3
+	// successful attempts without throttling that add one slot back
+	rampAttempts = 50
+)
+
+// aimd ... additive increase, multiplicative decrease control of a limiter for -concurrency-auto
+// the concurrency is lowered by occupying limiter slots itself and raised by freeing them
+type aimd struct {
+	limiter chan int
+	// closed by stop so slots still awaited when the scans end are given up
+	done    chan struct{}
+	waiting sync.WaitGroup
+
+	mu        sync.Mutex
+	held      int
+	pending   int
+	throttles int
+	successes int
+	// how the concurrency varied, reported with -debug
+	lowest    int
+	decreases int
+	increases int
+}
+
+func newAIMD(limiter chan int) *aimd {
+	return &aimd{limiter: limiter, done: make(chan struct{}), lowest: cap(limiter)}
+}
+
+// effective ... slots left for bucket scans, a caller holds mu
+func (a *aimd) effective() int {
+	return cap(a.limiter) - a.held - a.pending
+}
+
+// observe ... request.Handlers.CompleteAttempt handler counting throttled and successful attempts
+func (a *aimd) observe(r *request.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if r.Error != nil && request.IsErrorThrottle(r.Error) {
+		a.throttles++
+		a.successes = 0
+		if a.throttles >= throttleThreshold && a.effective() > 1 {
+			a.decrease()
+		}
+		return
+	}
+	if r.Error == nil {
+		a.successes++
+		if a.successes >= rampAttempts && a.held > 0 {
+			a.increase()
+		}
+	}
+}
+
+// decrease ... halve the effective concurrency, a caller holds mu
+func (a *aimd) decrease() {
+	before := a.effective()
+	n := before - before/2
+	a.pending += n
+	a.throttles = 0
+	a.decreases++
+	if before-n < a.lowest {
+		a.lowest = before - n
+	}
+	for i := 0; i < n; i++ {
+		// a free slot is taken at once, one in use is awaited until a bucket releases it or stop
+		select {
+		case a.limiter <- 1:
+			a.pending--
+			a.held++
+			continue
+		default:
+		}
+		a.waiting.Add(1)
+		go func() {
+			defer a.waiting.Done()
+			select {
+			case a.limiter <- 1:
+				a.mu.Lock()
+				a.pending--
+				a.held++
+				a.mu.Unlock()
+			case <-a.done:
+				a.mu.Lock()
+				a.pending--
+				a.mu.Unlock()
+			}
+		}()
+	}
+	if debug {
+		fmt.Fprintf(os.Stderr, "debug: concurrency %d -> %d after %d throttled requests\n", before, before-n, throttleThreshold)
+	}
+}
+
+// increase ... give one held slot back, a caller holds mu
+func (a *aimd) increase() {
+	before := a.effective()
+	<-a.limiter
+	a.held--
+	a.successes = 0
+	a.increases++
+	if debug {
+		fmt.Fprintf(os.Stderr, "debug: concurrency %d -> %d after %d requests without throttling\n", before, before+1, rampAttempts)
+	}
+}
+
+// stop ... give up the slots still awaited once the scans sharing the limiter are done,
+// so no goroutine of a decrease outlives them
+func (a *aimd) stop() {
+	close(a.done)
+	a.waiting.Wait()
+}
+
+// printSummary ... print how the concurrency varied to stderr under -debug
+func (a *aimd) printSummary() {
+	if !debug {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "debug: concurrency started at %d, lowest %d, ended at %d after %d decreases and %d increases\n",
+		cap(a.limiter), a.lowest, a.effective(), a.decreases, a.increases)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAIMDDecreaseTakesFreeSlots(t *testing.T) {
+	a := newAIMD(make(chan int, 8))
+	a.mu.Lock()
+	a.decrease()
+	a.mu.Unlock()
+	// nothing is scanning, so the halved slots are taken without waiting
+	if a.held != 4 || a.pending != 0 || len(a.limiter) != 4 {
+		t.Errorf("held %d, pending %d, limiter %d, want 4, 0, 4", a.held, a.pending, len(a.limiter))
+	}
+	a.stop()
+}
+
+func TestAIMDStopGivesUpAwaitedSlots(t *testing.T) {
+	limiter := make(chan int, 8)
+	for i := 0; i < cap(limiter); i++ {
+		limiter <- 1
+	}
+	a := newAIMD(limiter)
+	a.mu.Lock()
+	a.decrease()
+	a.mu.Unlock()
+	if got := a.effective(); got != 4 {
+		t.Fatalf("effective() = %d, want 4", got)
+	}
+
+	// a bucket finishing hands its slot to the controller
+	<-limiter
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		a.mu.Lock()
+		held := a.held
+		a.mu.Unlock()
+		if held == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("released slot was not taken by the decrease")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// the scans end with the rest still in use, stop must not leave goroutines waiting for them
+	stopped := make(chan struct{})
+	go func() {
+		a.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop() did not return, decrease goroutines are still blocked on the limiter")
+	}
+	if a.pending != 0 || a.held != 1 {
+		t.Errorf("after stop held %d, pending %d, want 1, 0", a.held, a.pending)
+	}
+}
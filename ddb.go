@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+const (
+	// BatchWriteItem accepts up to 25 items per request
+	ddbBatchSize  = 25
+	ddbMaxRetries = 5
+)
+
+// ddbItem ... figures of a bucket on date written to -ddb-table
+type ddbItem struct {
+	BucketName      string             `json:"bucketName"`
+	Date            string             `json:"date"`
+	Profile         string             `json:"profile"`
+	Region          string             `json:"region"`
+	NumberOfObjects float64            `json:"numberOfObjects"`
+	TotalSize       float64            `json:"totalSize"`
+	TotalCost       float64            `json:"totalCost"`
+	Sizes           map[string]float64 `json:"sizes"`
+	Costs           map[string]float64 `json:"costs"`
+}
+
+// writeDynamoDB ... put each bucket's figures keyed by (bucketName, date) of now in utc
+// buckets whose metrics could not be fetched are left out rather than written as zero
+func writeDynamoDB(clients *awsClients, buckets []Bucket, now time.Time) error {
+	svc := dynamodb.New(clients.sess, &clients.config, aws.NewConfig().WithRegion(ddbRegion))
+	date := now.UTC().Format("2006-01-02")
+	requests := []*dynamodb.WriteRequest{}
+	for _, bucket := range buckets {
+		if bucket.Err != nil {
+			fmt.Fprintf(os.Stderr, "not writing bucket %s to %s: %s\n", bucket.Name, ddbTable, errorSummary(bucket.Err))
+			continue
+		}
+		item, err := dynamodbattribute.MarshalMap(ddbItem{
+			BucketName:      bucket.Name,
+			Date:            date,
+			Profile:         bucket.Profile,
+			Region:          bucket.Region,
+			NumberOfObjects: bucket.NumberOfObjects,
+			TotalSize:       bucket.TotalSize,
+			TotalCost:       bucket.TotalCost,
+			Sizes:           bucket.Sizes,
+			Costs:           bucket.Costs,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to marshal bucket %s for dynamodb: %v", bucket.Name, err)
+		}
+		requests = append(requests, &dynamodb.WriteRequest{PutRequest: &dynamodb.PutRequest{Item: item}})
+	}
+
+	for start := 0; start < len(requests); start += ddbBatchSize {
+		end := start + ddbBatchSize
+		if end > len(requests) {
+			end = len(requests)
+		}
+		if err := batchWrite(svc, requests[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchWrite ... BatchWriteItem requests into -ddb-table, retrying unprocessed items with backoff
+// when the table runs out of write capacity
+func batchWrite(svc *dynamodb.DynamoDB, requests []*dynamodb.WriteRequest) error {
+	for retry := 0; len(requests) > 0; retry++ {
+		if retry > ddbMaxRetries {
+			return fmt.Errorf("unable to write %d items to %s: capacity exceeded after %d retries", len(requests), ddbTable, ddbMaxRetries)
+		}
+		if retry > 0 {
+			time.Sleep(time.Duration(100<<uint(retry-1)) * time.Millisecond)
+		}
+		resp, err := svc.BatchWriteItem(&dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]*dynamodb.WriteRequest{ddbTable: requests},
+		})
+		if err != nil {
+			return fmt.Errorf("unable to write to %s: %v", ddbTable, err)
+		}
+		requests = resp.UnprocessedItems[ddbTable]
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// debugMetricStatistics ... log a GetMetricStatistics query and its number of datapoints under -debug
+func debugMetricStatistics(params *cloudwatch.GetMetricStatisticsInput, resp *cloudwatch.GetMetricStatisticsOutput, err error) {
+	if !debug {
+		return
+	}
+	datapoints := 0
+	if resp != nil {
+		datapoints = len(resp.Datapoints)
+	}
+	fmt.Fprintf(os.Stderr, "debug: GetMetricStatistics %s %s %s window=%s..%s period=%d stat=%s datapoints=%d%s\n",
+		aws.StringValue(params.Namespace), aws.StringValue(params.MetricName), debugDimensions(params.Dimensions),
+		debugTime(params.StartTime), debugTime(params.EndTime), aws.Int64Value(params.Period),
+		strings.Join(aws.StringValueSlice(params.Statistics), ","), datapoints, debugError(err))
+}
+
+// debugMetricData ... log each GetMetricData query and its number of values under -debug
+func debugMetricData(params *cloudwatch.GetMetricDataInput, values map[string]int, err error) {
+	if !debug {
+		return
+	}
+	for _, query := range params.MetricDataQueries {
+		stat := query.MetricStat
+		fmt.Fprintf(os.Stderr, "debug: GetMetricData %s %s %s window=%s..%s period=%d stat=%s datapoints=%d%s\n",
+			aws.StringValue(stat.Metric.Namespace), aws.StringValue(stat.Metric.MetricName), debugDimensions(stat.Metric.Dimensions),
+			debugTime(params.StartTime), debugTime(params.EndTime), aws.Int64Value(stat.Period),
+			aws.StringValue(stat.Stat), values[aws.StringValue(query.Id)], debugError(err))
+	}
+}
+
+// debugDimensions ... dimensions as name=value pairs
+func debugDimensions(dimensions []*cloudwatch.Dimension) string {
+	pairs := []string{}
+	for _, dimension := range dimensions {
+		pairs = append(pairs, aws.StringValue(dimension.Name)+"="+aws.StringValue(dimension.Value))
+	}
+	return strings.Join(pairs, " ")
+}
+
+// debugTime ... t in RFC3339 UTC
+func debugTime(t *time.Time) string {
+	return aws.TimeValue(t).UTC().Format(time.RFC3339)
+}
+
+// debugError ... suffix describing err, empty without error
+func debugError(err error) string {
+	if err == nil {
+		return ""
+	}
+	return " error=" + errorSummary(err)
+}
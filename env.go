@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const envPrefix = "S3USAGE_"
+
+// readable names for single letter flags
+var envAliases = map[string]string{
+	"p": "PROFILE",
+	"v": "VERBOSE",
+	"o": "OUTPUT",
+}
+
+// envName ... environment variable name for flag, e.g. total-only -> S3USAGE_TOTAL_ONLY
+func envName(flagName string) string {
+	if alias, ok := envAliases[flagName]; ok {
+		return envPrefix + alias
+	}
+	return envPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// explicitFlags ... names of flags given on the command line
+func explicitFlags() map[string]bool {
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	return explicit
+}
+
+// applyEnv ... set flags not given on the command line from environment variables
+func applyEnv(explicit map[string]bool) {
+	flag.VisitAll(func(f *flag.Flag) {
+		if explicit[f.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if err := f.Value.Set(value); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid value %q for %s: %v\n", value, envName(f.Name), err)
+			os.Exit(2)
+		}
+	})
+}
+
+// applyConfig ... set flags given neither on the command line nor by environment variables
+// from a json file whose keys are flag names
+func applyConfig(path string, explicit map[string]bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to read config file: %v\n", err)
+		os.Exit(2)
+	}
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		fmt.Fprintf(os.Stderr, "unable to parse config file %s: %v\n", path, err)
+		os.Exit(2)
+	}
+	for name, raw := range values {
+		f := flag.Lookup(name)
+		if f == nil || name == "config" {
+			fmt.Fprintf(os.Stderr, "unknown option %q in config file %s\n", name, path)
+			os.Exit(2)
+		}
+		if _, ok := os.LookupEnv(envName(name)); ok || explicit[name] {
+			continue
+		}
+		value := configValue(raw)
+		if err := f.Value.Set(value); err != nil {
+			fmt.Fprintf(os.Stderr, "invalid value %q for %s in config file %s: %v\n", value, name, path, err)
+			os.Exit(2)
+		}
+	}
+}
+
+// configValue ... flag string of a json value, lists are joined with comma
+func configValue(raw interface{}) string {
+	switch v := raw.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		items := []string{}
+		for _, item := range v {
+			items = append(items, configValue(item))
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(raw)
+}
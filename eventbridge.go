@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+)
+
+const (
+	// PutEvents accepts up to 10 entries per request
+	eventBatchSize  = 10
+	eventMaxRetries = 5
+	eventSource     = "s3usage"
+)
+
+// usageEvent ... detail of the event published for a bucket, or for all buckets with -eventbridge-total
+type usageEvent struct {
+	BucketName      string  `json:"bucketName,omitempty"`
+	Profile         string  `json:"profile,omitempty"`
+	Region          string  `json:"region,omitempty"`
+	NumberOfBuckets int     `json:"numberOfBuckets,omitempty"`
+	NumberOfObjects float64 `json:"numberOfObjects"`
+	TotalSize       float64 `json:"totalSize"`
+	TotalCost       float64 `json:"totalCost"`
+	Date            string  `json:"date"`
+}
+
+// publishEvents ... put an event of each bucket's figures, or only of the totals with -eventbridge-total,
+// on the -eventbridge bus, buckets whose metrics could not be fetched are left out
+func publishEvents(clients *awsClients, buckets []Bucket, totals Totals, now time.Time) error {
+	svc := eventbridge.New(clients.sess, &clients.config, aws.NewConfig().WithRegion(eventBridgeRegion))
+	date := now.UTC().Format("2006-01-02")
+	events := []usageEvent{}
+	if eventBridgeTotal {
+		events = append(events, usageEvent{
+			NumberOfBuckets: totals.NumberOfBuckets,
+			NumberOfObjects: totals.NumberOfObjects,
+			TotalSize:       totals.TotalSize,
+			TotalCost:       totals.TotalCost,
+			Date:            date,
+		})
+	} else {
+		for _, bucket := range buckets {
+			if bucket.Err != nil {
+				fmt.Fprintf(os.Stderr, "not publishing bucket %s to %s: %s\n", bucket.Name, eventBus, errorSummary(bucket.Err))
+				continue
+			}
+			events = append(events, usageEvent{
+				BucketName:      bucket.Name,
+				Profile:         bucket.Profile,
+				Region:          bucket.Region,
+				NumberOfObjects: bucket.NumberOfObjects,
+				TotalSize:       bucket.TotalSize,
+				TotalCost:       bucket.TotalCost,
+				Date:            date,
+			})
+		}
+	}
+
+	entries := []*eventbridge.PutEventsRequestEntry{}
+	for _, event := range events {
+		detail, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("unable to marshal event for %s: %v", eventBus, err)
+		}
+		detailType := "S3 Bucket Usage"
+		if eventBridgeTotal {
+			detailType = "S3 Usage Total"
+		}
+		entries = append(entries, &eventbridge.PutEventsRequestEntry{
+			EventBusName: aws.String(eventBus),
+			Source:       aws.String(eventSource),
+			DetailType:   aws.String(detailType),
+			Detail:       aws.String(string(detail)),
+			Time:         aws.Time(now),
+		})
+	}
+
+	for start := 0; start < len(entries); start += eventBatchSize {
+		end := start + eventBatchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		if err := putEvents(svc, entries[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putEvents ... PutEvents entries on -eventbridge, retrying failed entries with backoff
+func putEvents(svc *eventbridge.EventBridge, entries []*eventbridge.PutEventsRequestEntry) error {
+	for retry := 0; len(entries) > 0; retry++ {
+		if retry > eventMaxRetries {
+			return fmt.Errorf("unable to publish %d events to %s after %d retries", len(entries), eventBus, eventMaxRetries)
+		}
+		if retry > 0 {
+			time.Sleep(time.Duration(100<<uint(retry-1)) * time.Millisecond)
+		}
+		resp, err := svc.PutEvents(&eventbridge.PutEventsInput{Entries: entries})
+		if err != nil {
+			return fmt.Errorf("unable to publish to %s: %v", eventBus, err)
+		}
+		// results are in the order of entries, failed ones carry an error code
+		failed := []*eventbridge.PutEventsRequestEntry{}
+		for i, result := range resp.Entries {
+			if result.ErrorCode != nil && i < len(entries) {
+				failed = append(failed, entries[i])
+			}
+		}
+		entries = failed
+	}
+	return nil
+}
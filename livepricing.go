@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/pricing"
+)
+
+// pricing api endpoint is only available in a few regions
+const pricingRegion = "us-east-1"
+
+// volumeTypes ... pricing api volumeType to the storage types priced by it
+var volumeTypes = map[string][]string{
+	"Standard":                            {"StandardStorage", "GlacierS3ObjectOverhead", "DeepArchiveS3ObjectOverhead"},
+	"Intelligent-Tiering Frequent Access": {"IntelligentTieringStorage"},
+	"Standard - Infrequent Access":        {"StandardIAStorage", "StandardIASizeOverhead", "StandardIAObjectOverhead"},
+	"One Zone - Infrequent Access":        {"OneZoneIAStorage", "OneZoneIASizeOverhead"},
+	"Reduced Redundancy":                  {"ReducedRedundancyStorage"},
+	"Glacier Instant Retrieval":           {"GlacierInstantRetrievalStorage", "GlacierIRSizeOverhead"},
+	"Amazon Glacier":                      {"GlacierStorage", "GlacierStagingStorage", "GlacierObjectOverhead"},
+	"Glacier Deep Archive":                {"DeepArchiveStorage", "DeepArchiveObjectOverhead", "DeepArchiveStagingStorage"},
+}
+
+// livePrices ... prices fetched from the aws price list api, cached per region for the run
+// storage types missing from the api fall back to fallback
+type livePrices struct {
+	svc      *pricing.Pricing
+	fallback PriceProvider
+	mu       sync.Mutex
+	regions  map[string]map[string]float64
+}
+
+func newLivePrices(clients *awsClients, fallback PriceProvider) *livePrices {
+	return &livePrices{
+		svc:      pricing.New(clients.sess, &clients.config, aws.NewConfig().WithRegion(pricingRegion)),
+		fallback: fallback,
+		regions:  map[string]map[string]float64{},
+	}
+}
+
+func (p *livePrices) Price(region, storageType string) (float64, bool) {
+	p.mu.Lock()
+	table, ok := p.regions[region]
+	if !ok {
+		table = p.fetch(region)
+		p.regions[region] = table
+	}
+	p.mu.Unlock()
+
+	if price, ok := table[storageType]; ok {
+		return price, true
+	}
+	return p.fallback.Price(region, storageType)
+}
+
+// fetch ... storage prices of region, empty on error
+func (p *livePrices) fetch(region string) map[string]float64 {
+	table := map[string]float64{}
+	params := &pricing.GetProductsInput{
+		ServiceCode: aws.String("AmazonS3"),
+		Filters: []*pricing.Filter{
+			{
+				Field: aws.String("regionCode"),
+				Type:  aws.String(pricing.FilterTypeTermMatch),
+				Value: aws.String(region),
+			},
+			{
+				Field: aws.String("productFamily"),
+				Type:  aws.String(pricing.FilterTypeTermMatch),
+				Value: aws.String("Storage"),
+			},
+		},
+	}
+	err := p.svc.GetProductsPages(params, func(page *pricing.GetProductsOutput, lastPage bool) bool {
+		for _, item := range page.PriceList {
+			volumeType, price, ok := parsePriceItem(item)
+			if !ok {
+				continue
+			}
+			for _, storageType := range volumeTypes[volumeType] {
+				table[storageType] = price
+			}
+		}
+		return true
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to fetch live prices for %s, using fallback prices: %v\n", region, err)
+	}
+	return table
+}
+
+// parsePriceItem ... volumeType and first tier USD price of a price list item
+func parsePriceItem(item aws.JSONValue) (string, float64, bool) {
+	product, _ := item["product"].(map[string]interface{})
+	attributes, _ := product["attributes"].(map[string]interface{})
+	volumeType, _ := attributes["volumeType"].(string)
+	if _, ok := volumeTypes[volumeType]; !ok {
+		return "", 0, false
+	}
+
+	terms, _ := item["terms"].(map[string]interface{})
+	onDemand, _ := terms["OnDemand"].(map[string]interface{})
+	for _, term := range onDemand {
+		term, _ := term.(map[string]interface{})
+		dimensions, _ := term["priceDimensions"].(map[string]interface{})
+		for _, dimension := range dimensions {
+			dimension, _ := dimension.(map[string]interface{})
+			if beginRange, _ := dimension["beginRange"].(string); beginRange != "0" {
+				continue
+			}
+			pricePerUnit, _ := dimension["pricePerUnit"].(map[string]interface{})
+			usd, _ := pricePerUnit["USD"].(string)
+			price, err := strconv.ParseFloat(usd, 64)
+			if err != nil {
+				continue
+			}
+			return volumeType, price, true
+		}
+	}
+	return "", 0, false
+}
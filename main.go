@@ -5,77 +5,634 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/aws/client"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+const (
+	binaryGB  = 1024 * 1024 * 1024
+	decimalGB = 1000 * 1000 * 1000
 )
 
 var (
-	profile       string
-	verbose       bool
-	sess          client.ConfigProvider
-	config        aws.Config
-	defaultRegion string = "ap-northeast-1"
-	costDef       map[string]float64
+	profile              string
+	profiles             string
+	profileRegionMap     string
+	limiterPerProfile    bool
+	autoConcurrency      bool
+	parallelSizes        bool
+	orgMode              bool
+	orgRole              string
+	accountIDs           string
+	namespace            string
+	credsFile            string
+	stsRegion            string
+	configFile           string
+	verbose              bool
+	quiet                bool
+	compact              bool
+	output               string
+	sortKey              string
+	sortKey2             string
+	sortStable           bool
+	aggregateBelow       float64
+	maxBuckets           int
+	assumeYes            bool
+	fieldNames           string
+	ownerField           bool
+	selected             []field
+	showIdentity         bool
+	templateText         string
+	reportTitle          string
+	outFile              string
+	serveAddr            string
+	serveTTL             time.Duration
+	appendOut            bool
+	gzipOut              bool
+	footerText           string
+	lifecycle            bool
+	totalOnly            bool
+	sumOnlyCost          bool
+	regionTotals         bool
+	versioning           bool
+	exact                bool
+	rawBytes             bool
+	rounding             string
+	compareRegions       string
+	flatten              bool
+	created              bool
+	noRegionLookup       bool
+	projection           bool
+	daysInMonth          int
+	requesterPays        bool
+	empty                string
+	activeDays           int
+	activeOnly           bool
+	growthThreshold      float64
+	iaOverheadThreshold  float64
+	tagsAll              bool
+	ddbTable             string
+	ddbRegion            string
+	eventBus             string
+	eventBridgeRegion    string
+	eventBridgeTotal     bool
+	sqliteFile           string
+	storageLens          string
+	aliasFile            string
+	storageLensTolerance float64
+	legacyMetrics        bool
+	discoverTypes        bool
+	fuzzyPricing         bool
+	metricMath           bool
+	debug                bool
+	showAPICost          bool
+	jitter               time.Duration
+	sinceText            string
+	untilText            string
+	since                time.Time
+	until                time.Time
+	timeoutPerBucket     time.Duration
+	replication          bool
+	rawTypes             bool
+	friendly             bool
+	ownerID              string
+	retryOnEmpty         int
+	retryBudget          int
+	failOnMissing        bool
+	skipInaccessible     bool
+	errorsOnly           bool
+	regionMismatch       bool
+	storageClassSummary  bool
+	defaultRegion        string = "ap-northeast-1"
+	costDef              map[string]float64
+	pricingFile          string
+	itMonitoringRate     float64
+	livePricing          bool
+	prices               PriceProvider
+	excludeTypes         string
+	storageTypes         []string
 )
 
+// startedAt ... when this run started, the time -title reports are labeled with
+var startedAt time.Time
+
 // Bucket ... usage by bucket
 type Bucket struct {
-	Name            string
-	Region          string
-	NumberOfObjects float64
-	TotalSize       float64
-	TotalCost       float64
-	Sizes           map[string]float64
-	Costs           map[string]float64
+	Name            string             `json:"name"`
+	Alias           string             `json:"alias,omitempty"`
+	Profile         string             `json:"profile"`
+	Region          string             `json:"region"`
+	NumberOfObjects float64            `json:"numberOfObjects"`
+	TotalSize       float64            `json:"totalSize"`
+	TotalCost       float64            `json:"totalCost"`
+	Sizes           map[string]float64 `json:"sizes"`
+	Costs           map[string]float64 `json:"costs"`
+	PricesUsed      map[string]float64 `json:"pricesUsed"`
+	LifecycleRules  int                `json:"lifecycleRules,omitempty"`
+	Versioning      string             `json:"versioning,omitempty"`
+	Replication     string             `json:"replication,omitempty"`
+	Payer           string             `json:"payer,omitempty"`
+	Owner           string             `json:"owner,omitempty"`
+	RegionCosts     map[string]float64 `json:"regionCosts,omitempty"`
+	CreationDate    *time.Time         `json:"creationDate,omitempty"`
+	NoData          bool               `json:"noData,omitempty"`
+	ObjectsChange   *float64           `json:"objectsChange,omitempty"`
+	Growth          *float64           `json:"growth,omitempty"`
+	Tags            map[string]string  `json:"tags,omitempty"`
+	Datapoints      map[string]int     `json:"datapoints,omitempty"`
+	SeenBy          []string           `json:"seenBy,omitempty"`
+	Err             error              `json:"-"`
+
+	// types ... storage types found by -discover-types, nil queries all of them
+	types []string
+}
+
+// sizeTypes ... storage types whose sizes are fetched for the bucket
+func (b Bucket) sizeTypes() []string {
+	if b.types == nil {
+		return storageTypes
+	}
+	return b.types
+}
+
+// pricedTypes ... scanned storage types and those -fuzzy-pricing discovered for the bucket
+func (b Bucket) pricedTypes() []string {
+	types := append([]string{}, storageTypes...)
+	for _, storageType := range b.types {
+		if !knownStorageType(storageType) {
+			types = append(types, storageType)
+		}
+	}
+	return types
+}
+
+// priceTable ... prices of the bucket's priced types in region, discovered ones priced by fuzzyPrice
+func (b Bucket) priceTable(region string) map[string]float64 {
+	table := priceTable(region)
+	for _, storageType := range b.pricedTypes()[len(storageTypes):] {
+		if price, ok := fuzzyPrice(region, storageType); ok {
+			table[storageType] = price
+		}
+	}
+	return table
 }
 
 func init() {
-	flag.StringVar(&profile, "p", "default", "aws shared credential profile name")
+	// tokyo region cost, the *ObjectOverhead types are priced by bytes as cloudwatch reports them as
+	// object count times 32KB/8KB and has no per type object count, so no per object price is offered
+	costDef = map[string]float64{
+		"StandardStorage":                0.025,
+		"IntelligentTieringStorage":      0.025,
+		"StandardIAStorage":              0.019,
+		"StandardIASizeOverhead":         0.019,
+		"StandardIAObjectOverhead":       0.019,
+		"OneZoneIAStorage":               0.0152,
+		"OneZoneIASizeOverhead":          0.0152,
+		"ReducedRedundancyStorage":       0.0259,
+		"GlacierInstantRetrievalStorage": 0.005,
+		"GlacierIRSizeOverhead":          0.005,
+		"GlacierStorage":                 0.005,
+		"GlacierStagingStorage":          0.005,
+		"GlacierObjectOverhead":          0.005,
+		"GlacierS3ObjectOverhead":        0.025,
+		"DeepArchiveStorage":             0.002,
+		"DeepArchiveObjectOverhead":      0.002,
+		"DeepArchiveS3ObjectOverhead":    0.025,
+		"DeepArchiveStagingStorage":      0.002,
+	}
+	prices = mapPrices(costDef)
+	storageTypes = sortedKeys(costDef)
+}
+
+// parseFlags ... register and parse flags, apply env and -config, and set up prices,
+// called first by main so tests can use the package without parsing the command line
+func parseFlags() {
+	defaultProfile := "default"
+	if env := os.Getenv("AWS_PROFILE"); env != "" {
+		defaultProfile = env
+	}
+	flag.StringVar(&profile, "p", defaultProfile, "aws shared credential profile name or comma separated names like -profiles, $AWS_PROFILE if set when omitted")
+	flag.StringVar(&profiles, "profiles", "", "comma separated profile names scanned concurrently into one report, overrides -p")
+	flag.StringVar(&profileRegionMap, "profile-region-map", "", "comma separated profile=region pairs of each profile's default region, e.g. gov=us-gov-west-1 for mixed partitions")
+	flag.BoolVar(&limiterPerProfile, "limiter-per-profile", false, "give each profile of -profiles its own concurrency limit instead of sharing one, if enabled")
+	flag.BoolVar(&parallelSizes, "parallel-sizes", false, "fetch BucketSizeBytes of every storage type of a bucket at once alongside NumberOfObjects instead of one type after another, if enabled")
+	flag.BoolVar(&autoConcurrency, "concurrency-auto", false, "halve the concurrency when cloudwatch throttles and ramp it back up gradually, changes are logged with -debug, if enabled")
+	flag.BoolVar(&orgMode, "org", false, "scan every active account of the organization listed with -p, assuming -org-role into each, if enabled")
+	flag.StringVar(&orgRole, "org-role", "OrganizationAccountAccessRole", "role name -org assumes in member accounts")
+	flag.StringVar(&accountIDs, "account-id", "", "comma separated account ids of the organization to scan, implies -org")
+	flag.StringVar(&stsRegion, "sts-region", "", "region of the sts regional endpoint used to check credentials (default: the profile's region)")
+	flag.StringVar(&credsFile, "credentials-file", "", "aws shared credentials file path (default: $AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials)")
+	flag.StringVar(&ownerID, "owner", "", "only buckets owned by this canonical user id")
 	flag.BoolVar(&verbose, "v", false, "show detail cost, if enabled")
+	flag.BoolVar(&quiet, "quiet", false, "omit the run summary line on stderr, if enabled")
+	flag.BoolVar(&compact, "compact", false, "print the -v detail of each bucket inline on one wrapped line, if enabled")
+	flag.BoolVar(&rawTypes, "raw-types", false, "show every storage type under -v instead of grouping Standard-IA with its overhead, if enabled")
+	flag.BoolVar(&friendly, "friendly-names", false, "show storage types as human labels like \"Standard-IA size overhead\" under -v and in the storage class summary, if enabled")
+	flag.StringVar(&output, "o", "table", "output format (table|markdown|json|grafana)")
+	flag.StringVar(&templateText, "template", "", "go text/template printed per bucket instead of -o, e.g. '{{.Name}},{{cost .TotalCost}}'")
+	flag.StringVar(&serveAddr, "serve", "", "listen on this address, e.g. :8080, serving /report in the -o format and /metrics for prometheus, scanning on request")
+	flag.DurationVar(&serveTTL, "serve-ttl", 5*time.Minute, "how long -serve reuses a scan before requests trigger another")
+	flag.StringVar(&outFile, "out", "", "write the report to this file instead of stdout")
+	flag.BoolVar(&appendOut, "append", false, "append the report to -out as a block headed by the run's start time instead of replacing the file, if enabled")
+	flag.BoolVar(&gzipOut, "gzip", false, "gzip compress the -out file, adding .gz to its name if missing, if enabled")
+	flag.StringVar(&reportTitle, "title", "", "report title shown with the run's start time above markdown output and in the json envelope")
+	flag.StringVar(&footerText, "template-footer", "", "go text/template printed once with the totals after -template lines")
+	flag.StringVar(&sortKey, "sort", "name", "order of buckets (name|cost|size|growth|region|none), cost, size and growth are largest first, none prints a single profile table as buckets complete")
+	flag.StringVar(&sortKey2, "sort2", "", "secondary order of buckets tying on -sort, same keys as -sort")
+	flag.Float64Var(&aggregateBelow, "aggregate-small-buckets", 0, "collapse buckets charged less than this many USD into one row after sorting, totals still include them")
+	flag.BoolVar(&sortStable, "sort-stable", false, "break -sort ties by bucket name so repeated runs print identical output, if enabled")
+	flag.StringVar(&fieldNames, "fields", "", "comma separated fields printed in this order instead of the default columns, e.g. name,cost,size")
+	flag.IntVar(&maxBuckets, "max-buckets", 0, "refuse to scan more than N buckets unless -yes is given, guarding against large cloudwatch bills")
+	flag.BoolVar(&assumeYes, "yes", false, "scan even when -max-buckets is exceeded, if enabled")
+	flag.BoolVar(&showIdentity, "show-identity", false, "print the account id and arn of each profile above the report (always with -v), if enabled")
+	flag.BoolVar(&lifecycle, "lifecycle", false, "show number of lifecycle rules, if enabled")
+	flag.BoolVar(&versioning, "versioning", false, "show versioning status (sizes include noncurrent versions), if enabled")
+	flag.BoolVar(&noRegionLookup, "no-region-lookup", false, "skip bucket region lookup and query metrics in the profile's default region ("+defaultRegion+" unless mapped) only, buckets in other regions show zero, if enabled")
+	flag.StringVar(&excludeTypes, "exclude-storage-types", "", "comma separated storage types neither queried nor priced, e.g. GlacierStorage,DeepArchiveStorage")
+	flag.StringVar(&sinceText, "since", "", "RFC3339 start of the metric window, e.g. 2020-04-30T00:00:00Z, instead of a few days before -until")
+	flag.StringVar(&untilText, "until", "", "RFC3339 end of the metric window instead of now, figures are the newest datapoint before it")
+	flag.DurationVar(&jitter, "jitter", 0, "random delay up to this duration before each bucket's first call to smooth the request burst, e.g. 300ms")
+	flag.BoolVar(&metricMath, "metric-math", false, "sum sizes and costs over storage types in cloudwatch with metric math, only without -v and other per type output, if enabled")
+	flag.DurationVar(&timeoutPerBucket, "timeout-per-bucket", 0, "give up a bucket whose metrics take longer than this and report it as timed out, e.g. 30s")
+	flag.BoolVar(&showAPICost, "show-api-cost", false, "print the cloudwatch requests and metrics of the run and their estimated charge, if enabled")
+	flag.BoolVar(&debug, "debug", false, "log each cloudwatch query and the number of datapoints returned to stderr, if enabled")
+	flag.BoolVar(&discoverTypes, "discover-types", false, "fetch sizes only of the storage types ListMetrics reports for each bucket, listed once per region, if enabled")
+	flag.BoolVar(&fuzzyPricing, "fuzzy-pricing", false, "also fetch storage types -discover-types finds outside the price table, priced like the known type their name starts with, if enabled")
+	flag.BoolVar(&legacyMetrics, "legacy-metrics", false, "fetch metrics with one GetMetricStatistics call per metric instead of GetMetricData, if enabled")
+	flag.StringVar(&namespace, "namespace", "AWS/S3", "cloudwatch namespace of the storage metrics")
+	flag.StringVar(&pricingFile, "pricing", "", "json file (or s3://bucket/key) of storage type to USD per GB-month, replaces built-in tokyo prices")
+	flag.Float64Var(&itMonitoringRate, "it-monitoring-rate", 0, "add the intelligent-tiering monitoring fee at this many USD per 1,000 objects per month, e.g. 0.0025")
+	flag.BoolVar(&livePricing, "live-pricing", false, "fetch prices of each region from the aws price list api, falling back to built-in or -pricing prices, if enabled")
+	flag.BoolVar(&rawBytes, "raw-bytes", false, "show sizes in bytes instead of gigabytes, if enabled")
+	flag.StringVar(&rounding, "rounding", "round", "how displayed sizes and costs are derived from exact values (round|trunc)")
+	flag.StringVar(&compareRegions, "compare-regions", "", "comma separated regions to price each bucket's storage in as if relocated there")
+	flag.BoolVar(&projection, "projection", false, "explain that charges are a monthly projection at the current size, if enabled")
+	flag.IntVar(&daysInMonth, "days-in-month", 0, "charge this many days of the current month instead of the whole month")
+	flag.StringVar(&empty, "empty", "na", "how size and cost of buckets without any size datapoint are shown (na|zero)")
+	flag.IntVar(&activeDays, "active-days", 0, "show whether each bucket's object count changed over the last N days")
+	flag.BoolVar(&activeOnly, "active-only", false, "only buckets whose object count changed over -active-days, if enabled")
+	flag.Float64Var(&growthThreshold, "growth-threshold", 0, "warn of buckets whose size grew more than this percent in a day")
+	flag.Float64Var(&iaOverheadThreshold, "ia-overhead-threshold", 0, "warn of buckets whose infrequent access size is more than this percent 128KB minimum size overhead")
+	flag.BoolVar(&exact, "exact", false, "show costs in full precision instead of cents, if enabled")
+	flag.BoolVar(&skipInaccessible, "skip-inaccessible", false, "skip buckets whose region or metrics are not accessible, if enabled")
+	flag.IntVar(&retryOnEmpty, "retry-on-empty", 0, "re-query sizes with a wider window up to N times when size is zero but objects exist")
+	flag.IntVar(&retryBudget, "retry-budget", 0, "stop retrying failed aws requests once this many retries were made in total, 0 is unlimited")
+	flag.BoolVar(&failOnMissing, "fail-on-missing-metrics", false, "list buckets without any size datapoint and exit with status 3 when there are some, if enabled")
+	flag.BoolVar(&regionMismatch, "only-region-mismatch", false, "show only buckets without datapoints next to the region GetBucketLocation reports, to find wrongly resolved regions, if enabled")
+	flag.BoolVar(&errorsOnly, "errors-only", false, "show only buckets whose region or metrics could not be fetched, if enabled")
+	flag.BoolVar(&storageClassSummary, "storage-class-summary", false, "show size and cost of each storage type summed over all buckets, largest cost first, also shown with -v, if enabled")
+	flag.BoolVar(&storageClassSummary, "breakdown", false, "same as -storage-class-summary")
+	flag.BoolVar(&requesterPays, "requester-pays", false, "show who pays for requests (BucketOwner or Requester), if enabled")
+	flag.BoolVar(&tagsAll, "tags-all", false, "include all bucket tags in json output, if enabled")
+	flag.BoolVar(&replication, "replication", false, "show replication status (CRR: cross region, SRR: same region), if enabled")
+	flag.BoolVar(&flatten, "flatten", false, "print one \"bucket storageType size cost\" line per non-zero storage type, if enabled")
+	flag.BoolVar(&created, "created", false, "show bucket creation date, if enabled")
+	flag.BoolVar(&totalOnly, "total-only", false, "show only the grand total, if enabled")
+	flag.BoolVar(&sumOnlyCost, "sum-only-cost", false, "print only the grand total charge as a bare number for scripts, messages go to stderr, if enabled")
+	flag.BoolVar(&regionTotals, "region-totals", false, "add a regions array of per-region totals to -o json, if enabled")
+	flag.StringVar(&ddbTable, "ddb-table", "", "dynamodb table (partition key bucketName, sort key date) each bucket's figures of the day are written to")
+	flag.StringVar(&ddbRegion, "ddb-region", defaultRegion, "region of the -ddb-table table")
+	flag.StringVar(&eventBus, "eventbridge", "", "eventbridge bus each bucket's figures are published to as s3usage events")
+	flag.StringVar(&eventBridgeRegion, "eventbridge-region", defaultRegion, "region of the -eventbridge bus")
+	flag.BoolVar(&eventBridgeTotal, "eventbridge-total", false, "publish only one event of the totals to -eventbridge, if enabled")
+	flag.StringVar(&sqliteFile, "sqlite", "", "sqlite database file each bucket's figures are appended to with the run time, run by the sqlite3 command")
+	flag.StringVar(&aliasFile, "alias-file", "", "json file of bucket name to the alias shown in reports, the name stays in a RawName column and json name")
+	flag.StringVar(&storageLens, "storage-lens", "", "s3 storage lens csv export to compare bucket sizes against")
+	flag.Float64Var(&storageLensTolerance, "storage-lens-tolerance", 5, "percent difference from -storage-lens beyond which a bucket is reported")
+	flag.StringVar(&configFile, "config", "", "json config file whose keys are option names, overridden by flags and env")
 	flag.Parse()
-	config = aws.Config{
-		Credentials: credentials.NewSharedCredentials("", profile),
+	explicit := explicitFlags()
+	applyEnv(explicit)
+	if configFile != "" {
+		applyConfig(configFile, explicit)
 	}
-	sess = session.Must(session.NewSession())
 
-	// tokyo region cost
-	costDef = map[string]float64{
-		"StandardStorage":             0.025,
-		"IntelligentTieringStorage":   0.025,
-		"StandardIAStorage":           0.019,
-		"StandardIASizeOverhead":      0.019,
-		"StandardIAObjectOverhead":    0.019,
-		"OneZoneIAStorage":            0.0152,
-		"OneZoneIASizeOverhead":       0.0152,
-		"ReducedRedundancyStorage":    0.0259,
-		"GlacierStorage":              0.005,
-		"GlacierStagingStorage":       0.005,
-		"GlacierObjectOverhead":       0.005,
-		"GlacierS3ObjectOverhead":     0.025,
-		"DeepArchiveStorage":          0.002,
-		"DeepArchiveObjectOverhead":   0.002,
-		"DeepArchiveS3ObjectOverhead": 0.025,
-		"DeepArchiveStagingStorage":   0.002,
+	if pricingFile != "" {
+		filePrices, err := loadPriceFile(pricingFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		prices = filePrices
+		storageTypes = filePrices.storageTypes()
+	}
+	if excludeTypes != "" {
+		storageTypes = excludeStorageTypes(storageTypes, splitList(excludeTypes))
+	}
+	if livePricing {
+		prices = newLivePrices(newAWSClients(profileNames()[0]), prices)
 	}
 }
 
 func main() {
+	startedAt = time.Now()
+	parseFlags()
+	if output != "table" && output != "markdown" && output != "json" && output != "grafana" {
+		fmt.Fprintf(os.Stderr, "unknown output format %s\n", output)
+		os.Exit(1)
+	}
+	if output == "grafana" && (fieldNames != "" || templateText != "" || totalOnly || errorsOnly || regionMismatch || flatten) {
+		fmt.Fprintln(os.Stderr, "-o grafana reports all buckets and cannot be combined with -fields, -template, -total-only, -errors-only, -only-region-mismatch or -flatten")
+		os.Exit(1)
+	}
+	if templateText != "" {
+		if err := parseTemplates(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if fieldNames != "" {
+		var err error
+		if selected, err = selectedFields(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		enableFieldSources(selected)
+	}
+	for _, key := range []string{sortKey, sortKey2} {
+		if key != "" && !knownSortKey(key) {
+			fmt.Fprintf(os.Stderr, "unknown sort key %s, expected one of %s\n", key, strings.Join(sortKeys, ","))
+			os.Exit(1)
+		}
+	}
+	if sortKey == "" {
+		fmt.Fprintln(os.Stderr, "-sort must not be empty")
+		os.Exit(1)
+	}
+	if rounding != "round" && rounding != "trunc" {
+		fmt.Fprintf(os.Stderr, "unknown rounding mode %s\n", rounding)
+		os.Exit(1)
+	}
+	if empty != "na" && empty != "zero" {
+		fmt.Fprintf(os.Stderr, "unknown empty mode %s\n", empty)
+		os.Exit(1)
+	}
+	if activeOnly && activeDays <= 0 {
+		fmt.Fprintln(os.Stderr, "-active-only requires -active-days")
+		os.Exit(1)
+	}
+	for _, pair := range splitList(profileRegionMap) {
+		if kv := strings.SplitN(pair, "=", 2); len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			fmt.Fprintf(os.Stderr, "invalid -profile-region-map entry %q, expected profile=region\n", pair)
+			os.Exit(1)
+		}
+	}
+	if err := parseWindow(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if !since.IsZero() && (activeDays > 0 || retryOnEmpty > 0) {
+		fmt.Fprintln(os.Stderr, "-since fixes the start of every metric window and cannot be combined with the lookbacks of -active-days or -retry-on-empty")
+		os.Exit(1)
+	}
+	if namespace == "" {
+		fmt.Fprintln(os.Stderr, "namespace must not be empty")
+		os.Exit(1)
+	}
+	if fuzzyPricing && !discoverTypes {
+		fmt.Fprintln(os.Stderr, "-fuzzy-pricing needs -discover-types to find storage types outside the price table")
+		os.Exit(1)
+	}
+	if accountIDs != "" {
+		orgMode = true
+	}
+	if orgMode && (profiles != "" || strings.Contains(profile, ",")) {
+		fmt.Fprintln(os.Stderr, "-org scans accounts of the organization with the single -p profile, not -profiles")
+		os.Exit(1)
+	}
+	if sqliteFile != "" {
+		if err := checkSQLite(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if serveAddr != "" && (outFile != "" || appendOut || gzipOut) {
+		fmt.Fprintln(os.Stderr, "-serve answers each request over http and cannot be combined with -out, -append or -gzip")
+		os.Exit(1)
+	}
+	if (appendOut || gzipOut) && outFile == "" {
+		fmt.Fprintln(os.Stderr, "-append and -gzip need -out")
+		os.Exit(1)
+	}
+
+	if noRegionLookup {
+		fmt.Fprintf(os.Stderr, "region lookup skipped: metrics are queried in each profile's default region (%s unless mapped) only, buckets in other regions are reported as zero\n", defaultRegion)
+	}
+
+	if aliasFile != "" {
+		var err error
+		if aliases, err = loadAliases(aliasFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	var lens map[string]float64
+	if storageLens != "" {
+		var err error
+		if lens, err = loadStorageLens(storageLens); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	if orgMode {
+		resolveOrgAccounts()
+	}
+	ctx := interruptContext()
+	identities := checkCredentials(profileNames())
+	showIdentities := showIdentity || (verbose && templateText == "")
+	if serveAddr != "" {
+		if showIdentities {
+			printIdentities(profileNames(), identities)
+		}
+		if err := serve(ctx, serveAddr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	targets := dedupTargets(prepareTargets(profileNames()))
+	checkMaxBuckets(targets)
+
+	// the -out file is only opened once every check which may exit has passed
+	if outFile != "" {
+		path := outFile
+		if gzipOut && !strings.HasSuffix(path, ".gz") {
+			path += ".gz"
+		}
+		var err error
+		if closeOutput, err = redirectOutput(path, appendOut, gzipOut); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	if showIdentities {
+		printIdentities(profileNames(), identities)
+	}
+	// a single profile table in completion order (-sort none) is printed as each bucket completes
+	stream := output == "table" && len(targets) == 1 && sortKey == "none" && sortKey2 == "" && !sortStable && !errorsOnly && !regionMismatch && !totalOnly && !sumOnlyCost && !flatten && templateText == "" && aggregateBelow <= 0
+	reporter := newReporter(os.Stdout)
+	var totals Totals
+	results := []Bucket{}
+	scanTargets(ctx, targets, func(bucket Bucket) {
+		totals.Add(bucket)
+		if stream {
+			exitOnError(reporter.WriteBucket(bucket))
+		}
+		results = append(results, bucket)
+	})
+	sortBuckets(results)
+
+	switch {
+	case errorsOnly:
+		printErrors(failedBuckets(results))
+	case regionMismatch:
+		printRegionChecks(checkRegions(targets, results))
+	case sumOnlyCost:
+		fmt.Println(formatCost(totals.TotalCost))
+	case totalOnly:
+		printTotals(totals)
+	case flatten:
+		printFlat(results)
+	default:
+		if !stream {
+			for _, bucket := range aggregateSmall(results) {
+				exitOnError(reporter.WriteBucket(bucket))
+			}
+		}
+		exitOnError(reporter.WriteTotals(totals))
+	}
+	if (storageClassSummary || verbose) && !errorsOnly && !regionMismatch && !totalOnly && !sumOnlyCost && !flatten && templateText == "" {
+		printStorageClassSummary(totals)
+	}
+	if projection {
+		printProjection()
+	}
+	if lens != nil {
+		printStorageLensDiff(results, lens)
+	}
+	if growthThreshold > 0 {
+		printGrowthWarnings(results)
+	}
+	if iaOverheadThreshold > 0 {
+		printIAOverheadWarnings(results)
+	}
+	printPricingFallbacks(results)
+	if showAPICost {
+		printAPICost()
+	}
+	printRunSummary(len(results))
+	closeOutput(true)
+	if ctx.Err() != nil {
+		printInterrupted(totals)
+		os.Exit(130)
+	}
+	if ddbTable != "" {
+		if err := writeDynamoDB(newAWSClients(profileNames()[0]), results, windowEnd()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if eventBus != "" {
+		if err := publishEvents(newAWSClients(profileNames()[0]), results, totals, windowEnd()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if sqliteFile != "" {
+		if err := writeSQLite(results, startedAt); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	if failOnMissing && printMissingMetrics(results) {
+		os.Exit(3)
+	}
+}
+
+// checkMaxBuckets ... exit when more than -max-buckets buckets would be scanned without -yes
+func checkMaxBuckets(targets []target) {
+	if err := maxBucketsError(targets); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// maxBucketsError ... error when more than -max-buckets buckets would be scanned without -yes
+func maxBucketsError(targets []target) error {
+	if maxBuckets <= 0 || assumeYes {
+		return nil
+	}
+	count := 0
+	for _, t := range targets {
+		count += len(t.buckets)
+	}
+	if count <= maxBuckets {
+		return nil
+	}
+	return fmt.Errorf("%d buckets exceed -max-buckets %d: scanning requests about %d cloudwatch metrics, which are charged; add -yes to scan anyway",
+		count, maxBuckets, count*(1+len(storageTypes)))
+}
+
+// enableFieldSources ... turn on the lookups the selected fields are read from
+func enableFieldSources(selected []field) {
+	for _, f := range selected {
+		switch f.name {
+		case "lifecycle":
+			lifecycle = true
+		case "versioning":
+			versioning = true
+		case "payer":
+			requesterPays = true
+		case "replication":
+			replication = true
+		case "owner":
+			ownerField = true
+		case "active":
+			if activeDays <= 0 {
+				fmt.Fprintln(os.Stderr, "field active requires -active-days")
+				os.Exit(1)
+			}
+		}
+	}
+}
+
+// interruptContext ... context canceled on the first SIGINT, a second one kills as usual
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		signal.Stop(sig)
+		cancel()
+	}()
+	return ctx
+}
+
+// listBuckets ... all buckets with the metadata ListBuckets returns
+// (S3 Express One Zone directory buckets are not among them, ListDirectoryBuckets is not in this SDK version)
+func listBuckets(s3Svc s3API) []*s3.Bucket {
+	resp, _ := s3Svc.ListBuckets(nil)
+	return resp.Buckets
+}
+
+// resolveRegions ... resolve bucket regions concurrently before scanning
+func resolveRegions(ra regionAPI, bucketNames []string) (map[string]string, map[string]error) {
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 
-	fmt.Println(" ObjectCount      GigaBytes    Charges-USD  BucketName (Region)")
-	bucketNames := getBucketNames()
-	limiter := make(chan int, 20)
+	regions := map[string]string{}
+	errs := map[string]error{}
+	limiter := make(chan int, maxConcurrency)
 	for _, bucketName := range bucketNames {
 		limiter <- 1
 		wg.Add(1)
@@ -84,72 +641,76 @@ func main() {
 				<-limiter
 				wg.Done()
 			}()
-			bucket := Bucket{
-				Name:   bucketName,
-				Region: getRegion(bucketName),
-				Sizes:  map[string]float64{},
-				Costs:  map[string]float64{},
-			}
-			bucket.NumberOfObjects = getNumberOfObjects(bucket)
-			for storageType, costGbMonth := range costDef {
-				tmpBytes := getBucketSizeGB(bucket, storageType)
-				bucket.Sizes[storageType] = tmpBytes
-				bucket.TotalSize += tmpBytes
-				bucket.Costs[storageType] = tmpBytes * costGbMonth
-				bucket.TotalCost += tmpBytes * costGbMonth
-			}
+			region, err := getRegion(ra, bucketName)
 			mu.Lock()
 			defer mu.Unlock()
-
-			// show result
-			fmt.Printf("%12d %14.2f %14.2f  %s (%s)\n",
-				int(bucket.NumberOfObjects),
-				bucket.TotalSize,
-				bucket.TotalCost,
-				bucket.Name,
-				bucket.Region)
-			if verbose {
-				for storageType := range costDef {
-					if bucket.Sizes[storageType] != 0.0 {
-						fmt.Printf(" %26.2f %14.2f   - %s\n",
-							bucket.Sizes[storageType],
-							bucket.Costs[storageType],
-							storageType)
-					}
-				}
-				fmt.Println()
+			regions[bucketName] = region
+			if err != nil {
+				errs[bucketName] = err
 			}
 		}(bucketName)
 	}
 	wg.Wait()
+	return regions, errs
 }
 
-func getBucketNames() []string {
-	bcuketNames := []string{}
-	s3Svc := s3.New(sess, config.WithRegion(defaultRegion))
-	resp, _ := s3Svc.ListBuckets(nil)
-	for _, b := range resp.Buckets {
-		bcuketNames = append(bcuketNames, *b.Name)
+// prewarmClients ... create cloudwatch clients for regions in use concurrently
+func prewarmClients(clients *awsClients, regions map[string]string) {
+	var wg sync.WaitGroup
+
+	inUse := map[string]bool{}
+	for _, region := range regions {
+		inUse[region] = true
+	}
+	for region := range inUse {
+		wg.Add(1)
+		go func(region string) {
+			defer wg.Done()
+			clients.CloudWatch(region)
+		}(region)
 	}
-	return bcuketNames
+	wg.Wait()
 }
 
-func getRegion(bucketName string) string {
-	region, err := s3manager.GetBucketRegion(context.Background(), sess, bucketName, defaultRegion)
-	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
-			fmt.Fprintf(os.Stderr, "unable to find bucket %s's region not found\n", bucketName)
-		}
+// getRegion ... region of bucket, a bucket deleted since listing fails with NotFound
+// which the scan reports as skipped
+func getRegion(ra regionAPI, bucketName string) (string, error) {
+	return ra.BucketRegion(bucketName)
+}
+
+// getNumberOfObjects ... newest daily object count of bucket and the number of datapoints returned
+func getNumberOfObjects(ctx context.Context, cwSvc cloudwatchAPI, bucket Bucket) (float64, int, error) {
+	params := numberOfObjectsInput(bucket, objectsWindowDays)
+	resp, err := cwSvc.GetMetricStatisticsWithContext(ctx, params)
+	debugMetricStatistics(params, resp, err)
+	if dp := latestDatapoint(resp); dp != nil {
+		return *dp.Average, len(resp.Datapoints), err
 	}
-	return region
+	return 0.0, 0, err
 }
 
-func getNumberOfObjects(bucket Bucket) float64 {
-	params := &cloudwatch.GetMetricStatisticsInput{
-		StartTime:  aws.Time(time.Now().Add(time.Duration(24) * time.Hour * -2)),
-		EndTime:    aws.Time(time.Now()),
+// getObjectCountChange ... newest minus oldest daily object count looking back days,
+// nil with fewer than two datapoints
+func getObjectCountChange(ctx context.Context, cwSvc cloudwatchAPI, bucket Bucket, days int) (*float64, error) {
+	params := numberOfObjectsInput(bucket, days)
+	resp, err := cwSvc.GetMetricStatisticsWithContext(ctx, params)
+	debugMetricStatistics(params, resp, err)
+	newest := latestDatapoint(resp)
+	if newest == nil || len(resp.Datapoints) < 2 {
+		return nil, err
+	}
+	oldest := resp.Datapoints[len(resp.Datapoints)-1]
+	change := *newest.Average - *oldest.Average
+	return &change, err
+}
+
+// numberOfObjectsInput ... daily object count of bucket over all storage types looking back days
+func numberOfObjectsInput(bucket Bucket, days int) *cloudwatch.GetMetricStatisticsInput {
+	return &cloudwatch.GetMetricStatisticsInput{
+		StartTime:  aws.Time(windowStart(days)),
+		EndTime:    aws.Time(windowEnd()),
 		MetricName: aws.String("NumberOfObjects"),
-		Namespace:  aws.String("AWS/S3"),
+		Namespace:  aws.String(namespace),
 		Period:     aws.Int64(86400),
 		Statistics: []*string{aws.String(cloudwatch.StatisticAverage)},
 		Dimensions: []*cloudwatch.Dimension{
@@ -164,24 +725,15 @@ func getNumberOfObjects(bucket Bucket) float64 {
 		},
 		Unit: aws.String(cloudwatch.StandardUnitCount),
 	}
-
-	cwSvc := cloudwatch.New(sess, config.WithRegion(bucket.Region))
-	resp, _ := cwSvc.GetMetricStatistics(params)
-	sort.Slice(resp.Datapoints, func(i, j int) bool {
-		return resp.Datapoints[i].Timestamp.Unix() > resp.Datapoints[j].Timestamp.Unix()
-	})
-	if resp.Datapoints != nil {
-		return *resp.Datapoints[0].Average
-	}
-	return 0.0
 }
 
-func getBucketSizeGB(bucket Bucket, storageType string) float64 {
+// getBucketSizeBytes ... daily sizes in bytes of storageType newest first, empty if there is no datapoint
+func getBucketSizeBytes(ctx context.Context, cwSvc cloudwatchAPI, bucket Bucket, storageType string, days int) ([]float64, error) {
 	params := &cloudwatch.GetMetricStatisticsInput{
-		StartTime:  aws.Time(time.Now().Add(time.Duration(24) * time.Hour * -3)),
-		EndTime:    aws.Time(time.Now()),
+		StartTime:  aws.Time(windowStart(days)),
+		EndTime:    aws.Time(windowEnd()),
 		MetricName: aws.String("BucketSizeBytes"),
-		Namespace:  aws.String("AWS/S3"),
+		Namespace:  aws.String(namespace),
 		Period:     aws.Int64(86400),
 		Statistics: []*string{aws.String(cloudwatch.StatisticAverage)},
 		Dimensions: []*cloudwatch.Dimension{
@@ -197,13 +749,65 @@ func getBucketSizeGB(bucket Bucket, storageType string) float64 {
 		Unit: aws.String(cloudwatch.StandardUnitBytes),
 	}
 
-	cwSvc := cloudwatch.New(sess, config.WithRegion(bucket.Region))
-	resp, _ := cwSvc.GetMetricStatistics(params)
+	resp, err := cwSvc.GetMetricStatisticsWithContext(ctx, params)
+	debugMetricStatistics(params, resp, err)
+	series := []float64{}
+	if latestDatapoint(resp) != nil {
+		for _, dp := range resp.Datapoints {
+			series = append(series, *dp.Average)
+		}
+	}
+	return series, err
+}
+
+// parseWindow ... parse -since and -until, the window must cover at least one daily period
+func parseWindow() error {
+	var err error
+	if sinceText != "" {
+		if since, err = time.Parse(time.RFC3339, sinceText); err != nil {
+			return fmt.Errorf("invalid -since: %v", err)
+		}
+	}
+	if untilText != "" {
+		if until, err = time.Parse(time.RFC3339, untilText); err != nil {
+			return fmt.Errorf("invalid -until: %v", err)
+		}
+	}
+	if !since.IsZero() && windowEnd().Sub(since) < 24*time.Hour {
+		return fmt.Errorf("metric window %s..%s is shorter than one day", since.Format(time.RFC3339), windowEnd().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// windowStart ... start of a metric window looking back days from windowEnd, or -since if given
+func windowStart(days int) time.Time {
+	if !since.IsZero() {
+		return since
+	}
+	return windowEnd().Add(time.Duration(24*days) * time.Hour * -1)
+}
+
+// windowEnd ... end of metric windows, -until if given otherwise now
+func windowEnd() time.Time {
+	if !until.IsZero() {
+		return until
+	}
+	return time.Now()
+}
+
+// latestDatapoint ... newest datapoint of resp, nil if there is none
+// datapoints of resp are left sorted newest first
+func latestDatapoint(resp *cloudwatch.GetMetricStatisticsOutput) *cloudwatch.Datapoint {
+	if resp == nil || len(resp.Datapoints) == 0 {
+		return nil
+	}
 	sort.Slice(resp.Datapoints, func(i, j int) bool {
 		return resp.Datapoints[i].Timestamp.Unix() > resp.Datapoints[j].Timestamp.Unix()
 	})
-	if resp.Datapoints != nil {
-		return *resp.Datapoints[0].Average / 1024 / 1024 / 1024
-	}
-	return 0.0
+	return resp.Datapoints[0]
+}
+
+// bytesToGB ... convert bytes to gigabytes using divisor (binaryGB or decimalGB)
+func bytesToGB(bytes float64, divisor float64) float64 {
+	return bytes / divisor
 }
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+func TestLatestDatapoint(t *testing.T) {
+	now := time.Now()
+	datapoint := func(daysAgo int, value float64) *cloudwatch.Datapoint {
+		return &cloudwatch.Datapoint{
+			Timestamp: aws.Time(now.Add(time.Duration(-24*daysAgo) * time.Hour)),
+			Average:   aws.Float64(value),
+		}
+	}
+	tests := []struct {
+		name string
+		resp *cloudwatch.GetMetricStatisticsOutput
+		want *float64
+	}{
+		{"nil response", nil, nil},
+		{"empty", &cloudwatch.GetMetricStatisticsOutput{}, nil},
+		{"single", &cloudwatch.GetMetricStatisticsOutput{Datapoints: []*cloudwatch.Datapoint{datapoint(1, 10)}}, aws.Float64(10)},
+		{"multiple", &cloudwatch.GetMetricStatisticsOutput{Datapoints: []*cloudwatch.Datapoint{
+			datapoint(3, 10), datapoint(1, 30), datapoint(2, 20),
+		}}, aws.Float64(30)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := latestDatapoint(tt.resp)
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("latestDatapoint() = %v, want nil", *got.Average)
+				}
+				return
+			}
+			if got == nil || *got.Average != *tt.want {
+				t.Fatalf("latestDatapoint() = %v, want %v", got, *tt.want)
+			}
+			// the rest is left newest first
+			for i := 1; i < len(tt.resp.Datapoints); i++ {
+				if tt.resp.Datapoints[i].Timestamp.After(*tt.resp.Datapoints[i-1].Timestamp) {
+					t.Errorf("datapoint %d is newer than datapoint %d", i, i-1)
+				}
+			}
+		})
+	}
+}
+
+func TestBytesToGB(t *testing.T) {
+	tests := []struct {
+		name    string
+		bytes   float64
+		divisor float64
+		want    float64
+	}{
+		{"zero", 0, binaryGB, 0},
+		{"binary", 1 << 30, binaryGB, 1},
+		{"decimal", 1e9, decimalGB, 1},
+		{"binary of decimal", 1e9, binaryGB, 1e9 / (1 << 30)},
+		{"decimal of binary", 1 << 30, decimalGB, 1.073741824},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bytesToGB(tt.bytes, tt.divisor); !almostEqual(got, tt.want) {
+				t.Errorf("bytesToGB(%v, %v) = %v, want %v", tt.bytes, tt.divisor, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetBucketSizeBytes(t *testing.T) {
+	tests := []struct {
+		name   string
+		series []float64
+		want   []float64
+	}{
+		{"no datapoint", nil, []float64{}},
+		{"one datapoint", []float64{10}, []float64{10}},
+		{"newest first", []float64{30, 20, 10}, []float64{30, 20, 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cw := &fakeCloudWatch{series: map[string][]float64{}}
+			if tt.series != nil {
+				cw.series[fakeKey("bucket", "BucketSizeBytes", "StandardStorage")] = tt.series
+			}
+			got, err := getBucketSizeBytes(context.Background(), cw, Bucket{Name: "bucket"}, "StandardStorage", sizeWindowDays)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getBucketSizeBytes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetNumberOfObjects(t *testing.T) {
+	tests := []struct {
+		name       string
+		series     []float64
+		want       float64
+		datapoints int
+	}{
+		{"no datapoint", nil, 0, 0},
+		{"one datapoint", []float64{5}, 5, 1},
+		{"newest of several", []float64{7, 6, 5}, 7, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cw := &fakeCloudWatch{series: map[string][]float64{}}
+			if tt.series != nil {
+				cw.series[fakeKey("bucket", "NumberOfObjects", "AllStorageTypes")] = tt.series
+			}
+			got, n, err := getNumberOfObjects(context.Background(), cw, Bucket{Name: "bucket"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || n != tt.datapoints {
+				t.Errorf("getNumberOfObjects() = %v, %d, want %v, %d", got, n, tt.want, tt.datapoints)
+			}
+		})
+	}
+}
+
+func TestApplySizesConversion(t *testing.T) {
+	tests := []struct {
+		name     string
+		rawBytes bool
+		bytes    float64
+		want     float64
+	}{
+		{"gigabytes", false, 3 * binaryGB, 3},
+		{"half a gigabyte", false, binaryGB / 2, 0.5},
+		{"raw bytes", true, 3 * binaryGB, 3 * binaryGB},
+	}
+	defer func(saved bool) { rawBytes = saved }(rawBytes)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rawBytes = tt.rawBytes
+			bucket := Bucket{Region: defaultRegion}
+			applySizes(&bucket, map[string]float64{"StandardStorage": tt.bytes}, nil)
+			if bucket.TotalSize != tt.want || bucket.Sizes["StandardStorage"] != tt.want {
+				t.Errorf("TotalSize = %v, Sizes = %v, want %v", bucket.TotalSize, bucket.Sizes["StandardStorage"], tt.want)
+			}
+			// costs are charged per GB-month whatever unit sizes are shown in
+			if want := tt.bytes / binaryGB * costDef["StandardStorage"]; !almostEqual(bucket.TotalCost, want) {
+				t.Errorf("TotalCost = %v, want %v", bucket.TotalCost, want)
+			}
+		})
+	}
+}
+
+// benchmarkClients ... time the cloudwatch client lookups of 160 buckets in 16 regions as the first calls
+// of a scan make them, creating the clients on first use or finding them pre-warmed outside the timer
+func benchmarkClients(b *testing.B, prewarm bool) {
+	regionNames := []string{
+		"us-east-1", "us-east-2", "us-west-1", "us-west-2", "ca-central-1", "eu-west-1", "eu-west-2", "eu-west-3",
+		"eu-central-1", "eu-north-1", "ap-northeast-1", "ap-northeast-2", "ap-southeast-1", "ap-southeast-2", "ap-south-1", "sa-east-1",
+	}
+	regions := map[string]string{}
+	for i := 0; i < 160; i++ {
+		regions[fmt.Sprintf("bucket-%03d", i)] = regionNames[i%len(regionNames)]
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// only the lookups of the scan are timed, creating clients beforehand is setup
+		b.StopTimer()
+		clients := newAWSClients("default")
+		if prewarm {
+			prewarmClients(clients, regions)
+		}
+		b.StartTimer()
+		var wg sync.WaitGroup
+		limiter := make(chan int, maxConcurrency)
+		for _, region := range regions {
+			limiter <- 1
+			wg.Add(1)
+			go func(region string) {
+				defer func() {
+					<-limiter
+					wg.Done()
+				}()
+				clients.CloudWatch(region)
+			}(region)
+		}
+		wg.Wait()
+	}
+}
+
+func BenchmarkClientsOnFirstUse(b *testing.B) {
+	benchmarkClients(b, false)
+}
+
+func BenchmarkPrewarmClients(b *testing.B) {
+	benchmarkClients(b, true)
+}
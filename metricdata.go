@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// fetchMetricData ... object count, and newest and previous size in bytes of each storage type
+// with a datapoint looking back days,
+// all metrics of the bucket in one GetMetricData request, following NextToken,
+// datapoints receives the number of values of each metric
+func fetchMetricData(ctx context.Context, cwSvc cloudwatchAPI, bucket Bucket, days int, datapoints map[string]int) (float64, map[string]float64, map[string]float64, error) {
+	queries := []*cloudwatch.MetricDataQuery{
+		metricQuery("objects", bucket.Name, "NumberOfObjects", "AllStorageTypes", cloudwatch.StandardUnitCount),
+	}
+	idTypes := map[string]string{}
+	for i, storageType := range bucket.sizeTypes() {
+		id := fmt.Sprintf("size%d", i)
+		idTypes[id] = storageType
+		queries = append(queries, metricQuery(id, bucket.Name, "BucketSizeBytes", storageType, cloudwatch.StandardUnitBytes))
+	}
+	params := &cloudwatch.GetMetricDataInput{
+		StartTime:         aws.Time(windowStart(days)),
+		EndTime:           aws.Time(windowEnd()),
+		MetricDataQueries: queries,
+		ScanBy:            aws.String(cloudwatch.ScanByTimestampDescending),
+	}
+
+	// a query's values may continue on later pages, newest first by ScanBy
+	series := map[string][]float64{}
+	values := map[string]int{}
+	err := cwSvc.GetMetricDataPagesWithContext(ctx, params, func(page *cloudwatch.GetMetricDataOutput, lastPage bool) bool {
+		for _, result := range page.MetricDataResults {
+			id := aws.StringValue(result.Id)
+			values[id] += len(result.Values)
+			series[id] = append(series[id], aws.Float64ValueSlice(result.Values)...)
+		}
+		return true
+	})
+	debugMetricData(params, values, err)
+	datapoints["NumberOfObjects"] = values["objects"]
+	for id, storageType := range idTypes {
+		datapoints[storageType] = values[id]
+	}
+
+	count := 0.0
+	if objects := series["objects"]; len(objects) > 0 {
+		count = objects[0]
+	}
+	sizeBytes := map[string]float64{}
+	prevBytes := map[string]float64{}
+	for id, storageType := range idTypes {
+		splitSeries(storageType, series[id], sizeBytes, prevBytes)
+	}
+	return count, sizeBytes, prevBytes, err
+}
+
+// useMetricMath ... whether -metric-math applies, every option needing sizes per storage type
+// keeps the per type path
+func useMetricMath() bool {
+	return metricMath && !legacyMetrics && !verbose && !flatten && !storageClassSummary && templateText == "" && iaOverheadThreshold <= 0 && itMonitoringRate <= 0 &&
+		!jsonOutput() && ddbTable == "" && sqliteFile == "" && len(comparedRegions()) == 0
+}
+
+// fetchMetricMath ... object count, and newest first series of total bytes and cost per month of bucket
+// looking back days, summed over storage types by metric math so per type sizes are not returned,
+// datapoints receives the number of values of the object count and the summed size
+func fetchMetricMath(ctx context.Context, cwSvc cloudwatchAPI, bucket Bucket, days int, datapoints map[string]int) (float64, []float64, []float64, error) {
+	queries := []*cloudwatch.MetricDataQuery{
+		metricQuery("objects", bucket.Name, "NumberOfObjects", "AllStorageTypes", cloudwatch.StandardUnitCount),
+	}
+	sizeIDs := []string{}
+	costTerms := []string{}
+	for i, storageType := range bucket.sizeTypes() {
+		id := fmt.Sprintf("size%d", i)
+		query := metricQuery(id, bucket.Name, "BucketSizeBytes", storageType, cloudwatch.StandardUnitBytes)
+		query.ReturnData = aws.Bool(false)
+		queries = append(queries, query)
+		sizeIDs = append(sizeIDs, id)
+		price, ok := prices.Price(bucket.Region, storageType)
+		if !ok && fuzzyPricing {
+			price, ok = fuzzyPrice(bucket.Region, storageType)
+		}
+		if ok && price != 0 {
+			// USD per byte-month
+			costTerms = append(costTerms, id+"*"+strconv.FormatFloat(price/binaryGB, 'g', -1, 64))
+		}
+	}
+	if len(sizeIDs) > 0 {
+		queries = append(queries, &cloudwatch.MetricDataQuery{
+			Id:         aws.String("total"),
+			Expression: aws.String("SUM([" + strings.Join(sizeIDs, ",") + "])"),
+		})
+	}
+	if len(costTerms) > 0 {
+		queries = append(queries, &cloudwatch.MetricDataQuery{
+			Id:         aws.String("cost"),
+			Expression: aws.String("SUM([" + strings.Join(costTerms, ",") + "])"),
+		})
+	}
+	params := &cloudwatch.GetMetricDataInput{
+		StartTime:         aws.Time(windowStart(days)),
+		EndTime:           aws.Time(windowEnd()),
+		MetricDataQueries: queries,
+		ScanBy:            aws.String(cloudwatch.ScanByTimestampDescending),
+	}
+
+	series := map[string][]float64{}
+	err := cwSvc.GetMetricDataPagesWithContext(ctx, params, func(page *cloudwatch.GetMetricDataOutput, lastPage bool) bool {
+		for _, result := range page.MetricDataResults {
+			id := aws.StringValue(result.Id)
+			series[id] = append(series[id], aws.Float64ValueSlice(result.Values)...)
+		}
+		return true
+	})
+	if debug {
+		fmt.Fprintf(os.Stderr, "debug: GetMetricData %s metric math window=%s..%s datapoints objects=%d total=%d cost=%d%s\n",
+			bucket.Name, debugTime(params.StartTime), debugTime(params.EndTime),
+			len(series["objects"]), len(series["total"]), len(series["cost"]), debugError(err))
+	}
+
+	datapoints["NumberOfObjects"] = len(series["objects"])
+	datapoints["BucketSizeBytes"] = len(series["total"])
+
+	count := 0.0
+	if objects := series["objects"]; len(objects) > 0 {
+		count = objects[0]
+	}
+	return count, series["total"], series["cost"], err
+}
+
+// metricQuery ... daily average of an s3 storage metric of bucket
+func metricQuery(id, bucketName, metricName, storageType, unit string) *cloudwatch.MetricDataQuery {
+	return &cloudwatch.MetricDataQuery{
+		Id: aws.String(id),
+		MetricStat: &cloudwatch.MetricStat{
+			Metric: &cloudwatch.Metric{
+				Namespace:  aws.String(namespace),
+				MetricName: aws.String(metricName),
+				Dimensions: []*cloudwatch.Dimension{
+					{
+						Name:  aws.String("BucketName"),
+						Value: aws.String(bucketName),
+					},
+					{
+						Name:  aws.String("StorageType"),
+						Value: aws.String(storageType),
+					},
+				},
+			},
+			Period: aws.Int64(86400),
+			Stat:   aws.String(cloudwatch.StatisticAverage),
+			Unit:   aws.String(unit),
+		},
+	}
+}
+
+// discovered ... -discover-types results of each profile and region
+var discovered = typeCache{regions: map[string]*regionTypes{}}
+
+// typeCache ... storage types with a BucketSizeBytes metric of every bucket, listed once per profile and region
+type typeCache struct {
+	mu      sync.Mutex
+	regions map[string]*regionTypes
+}
+
+// regionTypes ... storage types of each bucket in one region, err if listing failed
+type regionTypes struct {
+	once    sync.Once
+	buckets map[string]map[string]bool
+	err     error
+}
+
+// reset ... forget the listed regions so the next scan lists their metrics again
+func (c *typeCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.regions = map[string]*regionTypes{}
+}
+
+// storageTypes ... scanned storage types reported for bucket, the region's metrics are listed
+// by the first bucket asking and shared with the others, ListMetrics only knows metrics
+// with data in the past two weeks
+func (c *typeCache) storageTypes(ctx context.Context, cwSvc cloudwatchAPI, bucket Bucket) ([]string, error) {
+	c.mu.Lock()
+	region, ok := c.regions[bucket.Profile+"/"+bucket.Region]
+	if !ok {
+		region = &regionTypes{}
+		c.regions[bucket.Profile+"/"+bucket.Region] = region
+	}
+	c.mu.Unlock()
+	region.once.Do(func() {
+		region.buckets, region.err = listStorageTypes(ctx, cwSvc)
+		if debug {
+			fmt.Fprintf(os.Stderr, "debug: ListMetrics %s BucketSizeBytes region=%s buckets=%d%s\n",
+				namespace, bucket.Region, len(region.buckets), debugError(region.err))
+		}
+	})
+	if region.err != nil {
+		return nil, region.err
+	}
+	types := []string{}
+	for _, storageType := range storageTypes {
+		if region.buckets[bucket.Name][storageType] {
+			types = append(types, storageType)
+		}
+	}
+	if fuzzyPricing {
+		// storage types unknown to the price table are priced by fuzzyPrice
+		for _, storageType := range sortedTypes(region.buckets[bucket.Name]) {
+			if !knownStorageType(storageType) {
+				types = append(types, storageType)
+			}
+		}
+	}
+	return types, nil
+}
+
+// listStorageTypes ... storage types of each bucket with a BucketSizeBytes metric in cwSvc's region
+func listStorageTypes(ctx context.Context, cwSvc cloudwatchAPI) (map[string]map[string]bool, error) {
+	params := &cloudwatch.ListMetricsInput{
+		Namespace:  aws.String(namespace),
+		MetricName: aws.String("BucketSizeBytes"),
+	}
+	buckets := map[string]map[string]bool{}
+	err := cwSvc.ListMetricsPagesWithContext(ctx, params, func(page *cloudwatch.ListMetricsOutput, lastPage bool) bool {
+		for _, metric := range page.Metrics {
+			var bucketName, storageType string
+			for _, dimension := range metric.Dimensions {
+				switch aws.StringValue(dimension.Name) {
+				case "BucketName":
+					bucketName = aws.StringValue(dimension.Value)
+				case "StorageType":
+					storageType = aws.StringValue(dimension.Value)
+				}
+			}
+			if buckets[bucketName] == nil {
+				buckets[bucketName] = map[string]bool{}
+			}
+			buckets[bucketName][storageType] = true
+		}
+		return true
+	})
+	return buckets, err
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+func TestFetchMetricDataMatchesPerType(t *testing.T) {
+	cw, buckets := fixture(3)
+	// a bucket with a single day of Glacier only and one without any metric
+	cw.series[fakeKey("sparse", "NumberOfObjects", "AllStorageTypes")] = []float64{42}
+	cw.series[fakeKey("sparse", "BucketSizeBytes", "GlacierStorage")] = []float64{5 * binaryGB}
+	buckets = append(buckets, Bucket{Name: "sparse", Region: defaultRegion}, Bucket{Name: "empty", Region: defaultRegion})
+
+	for _, bucket := range buckets {
+		t.Run(bucket.Name, func(t *testing.T) {
+			legacyPoints, dataPoints := map[string]int{}, map[string]int{}
+			count, sizeBytes, prevBytes, err := fetchMetrics(context.Background(), cw, bucket, sizeWindowDays, legacyPoints)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dataCount, dataSizes, dataPrev, err := fetchMetricData(context.Background(), cw, bucket, sizeWindowDays, dataPoints)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dataCount != count {
+				t.Errorf("object count = %v, per type %v", dataCount, count)
+			}
+			if !reflect.DeepEqual(dataSizes, sizeBytes) {
+				t.Errorf("sizes = %v, per type %v", dataSizes, sizeBytes)
+			}
+			if !reflect.DeepEqual(dataPrev, prevBytes) {
+				t.Errorf("previous sizes = %v, per type %v", dataPrev, prevBytes)
+			}
+			if !reflect.DeepEqual(dataPoints, legacyPoints) {
+				t.Errorf("datapoints = %v, per type %v", dataPoints, legacyPoints)
+			}
+		})
+	}
+}
+
+func TestUseMetricMathNeedsPerTypeSizes(t *testing.T) {
+	savedMath, savedRate, savedTable := metricMath, itMonitoringRate, ddbTable
+	defer func() { metricMath, itMonitoringRate, ddbTable = savedMath, savedRate, savedTable }()
+	metricMath = true
+	if !useMetricMath() {
+		t.Fatal("useMetricMath() = false with -metric-math alone")
+	}
+	itMonitoringRate = 0.0025
+	if useMetricMath() {
+		t.Error("useMetricMath() = true with -it-monitoring-rate, the fee needs per type sizes")
+	}
+	itMonitoringRate = 0
+	ddbTable = "usage"
+	if useMetricMath() {
+		t.Error("useMetricMath() = true with -ddb-table, the items store per type sizes and costs")
+	}
+}
+
+// pagedCloudWatch ... cloudwatch client whose GetMetricData is answered from pages keyed by the NextToken
+// of the request, so the SDK's own pagination follows them, tokens records the NextToken of each call
+func pagedCloudWatch(pages map[string]*cloudwatch.GetMetricDataOutput, tokens *[]string) *cloudwatch.CloudWatch {
+	svc := cloudwatch.New(session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String(defaultRegion),
+		Credentials: credentials.AnonymousCredentials,
+	})))
+	svc.Handlers.Send.Clear()
+	svc.Handlers.UnmarshalMeta.Clear()
+	svc.Handlers.Unmarshal.Clear()
+	svc.Handlers.ValidateResponse.Clear()
+	svc.Handlers.Send.PushBack(func(r *request.Request) {
+		token := aws.StringValue(r.Params.(*cloudwatch.GetMetricDataInput).NextToken)
+		*tokens = append(*tokens, token)
+		*r.Data.(*cloudwatch.GetMetricDataOutput) = *pages[token]
+		r.HTTPResponse = &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(""))}
+	})
+	return svc
+}
+
+func TestFetchMetricDataPagination(t *testing.T) {
+	result := func(id string, values ...float64) *cloudwatch.MetricDataResult {
+		return &cloudwatch.MetricDataResult{Id: aws.String(id), Values: aws.Float64Slice(values)}
+	}
+	// size0 is StandardStorage and size1 GlacierStorage, each series continues on the next page newest first
+	pages := map[string]*cloudwatch.GetMetricDataOutput{
+		"": {
+			MetricDataResults: []*cloudwatch.MetricDataResult{result("objects", 3000), result("size0", 30)},
+			NextToken:         aws.String("page2"),
+		},
+		"page2": {
+			MetricDataResults: []*cloudwatch.MetricDataResult{result("objects", 2990), result("size0", 20, 10)},
+			NextToken:         aws.String("page3"),
+		},
+		"page3": {
+			MetricDataResults: []*cloudwatch.MetricDataResult{result("size0"), result("size1", 5)},
+		},
+	}
+	defer func(saved string) { namespace = saved }(namespace)
+	namespace = "AWS/S3"
+	var tokens []string
+	bucket := Bucket{Name: "bucket", Region: defaultRegion, types: []string{"StandardStorage", "GlacierStorage"}}
+	datapoints := map[string]int{}
+	count, sizeBytes, prevBytes, err := fetchMetricData(context.Background(), pagedCloudWatch(pages, &tokens), bucket, sizeWindowDays, datapoints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"", "page2", "page3"}; !reflect.DeepEqual(tokens, want) {
+		t.Errorf("requested NextTokens %q, want %q", tokens, want)
+	}
+	if count != 3000 {
+		t.Errorf("object count = %v, want the newest 3000", count)
+	}
+	if want := map[string]float64{"StandardStorage": 30, "GlacierStorage": 5}; !reflect.DeepEqual(sizeBytes, want) {
+		t.Errorf("sizes = %v, want %v", sizeBytes, want)
+	}
+	if want := map[string]float64{"StandardStorage": 20}; !reflect.DeepEqual(prevBytes, want) {
+		t.Errorf("previous sizes = %v, want %v", prevBytes, want)
+	}
+	if want := map[string]int{"NumberOfObjects": 2, "StandardStorage": 3, "GlacierStorage": 1}; !reflect.DeepEqual(datapoints, want) {
+		t.Errorf("datapoints = %v, want %v", datapoints, want)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// orgAccount ... member account of -org scanned as a profile named by its account id
+type orgAccount struct {
+	name string
+	// roleARN ... role assumed with the -p profile's credentials, empty for the caller's own account
+	roleARN string
+}
+
+// orgAccounts ... -org accounts by account id, orgNames ... their ids with the caller's account first,
+// orgRegion ... default region of the -p profile used for all of them
+var (
+	orgAccounts = map[string]orgAccount{}
+	orgNames    []string
+	orgRegion   string
+)
+
+// orgCredentials ... credentials of the -org account id assuming its role from the -p profile,
+// false if name is not an -org account
+func orgCredentials(name string, sess client.ConfigProvider) (*credentials.Credentials, bool) {
+	account, ok := orgAccounts[name]
+	if !ok {
+		return nil, false
+	}
+	base := credentials.NewSharedCredentials(credsFile, profile)
+	if account.roleARN == "" {
+		return base, true
+	}
+	region := orgRegion
+	if stsRegion != "" {
+		region = stsRegion
+	}
+	svc := sts.New(sess, &aws.Config{Credentials: base}, aws.NewConfig().WithRegion(region).WithSTSRegionalEndpoint(endpoints.RegionalSTSEndpoint))
+	return stscreds.NewCredentialsWithClient(svc, account.roleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = "s3usage"
+	}), true
+}
+
+// resolveOrgAccounts ... list active member accounts of the organization with the -p profile,
+// limited to -account-id if given, and keep those whose -org-role can be assumed, warning of the others
+func resolveOrgAccounts() {
+	base := newAWSClients(profile)
+	orgRegion = base.region
+	identity, err := base.CallerIdentity()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "credentials of profile %s are missing, invalid or expired: %s\n", profile, errorSummary(err))
+		os.Exit(1)
+	}
+	callerAccount := aws.StringValue(identity.Account)
+	partition := "aws"
+	if parts := strings.Split(aws.StringValue(identity.Arn), ":"); len(parts) > 1 {
+		partition = parts[1]
+	}
+
+	wanted := map[string]bool{}
+	for _, id := range splitList(accountIDs) {
+		wanted[id] = true
+	}
+	svc := organizations.New(base.sess, &base.config, aws.NewConfig().WithRegion(base.region))
+	accounts := map[string]orgAccount{}
+	err = svc.ListAccountsPages(&organizations.ListAccountsInput{}, func(page *organizations.ListAccountsOutput, lastPage bool) bool {
+		for _, account := range page.Accounts {
+			id := aws.StringValue(account.Id)
+			if aws.StringValue(account.Status) != organizations.AccountStatusActive || (len(wanted) > 0 && !wanted[id]) {
+				continue
+			}
+			roleARN := ""
+			if id != callerAccount {
+				roleARN = fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, id, orgRole)
+			}
+			accounts[id] = orgAccount{aws.StringValue(account.Name), roleARN}
+		}
+		return true
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to list accounts of the organization with profile %s: %s\n", profile, errorSummary(err))
+		os.Exit(1)
+	}
+	for _, id := range splitList(accountIDs) {
+		if _, ok := accounts[id]; !ok {
+			fmt.Fprintf(os.Stderr, "account %s of -account-id is not an active account of the organization\n", id)
+		}
+	}
+	orgAccounts = accounts
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	skipped := []string{}
+	for id, account := range accounts {
+		if account.roleARN == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(id string, account orgAccount) {
+			defer wg.Done()
+			if _, err := newAWSClients(id).CallerIdentity(); err != nil {
+				fmt.Fprintf(os.Stderr, "skipping account %s (%s): unable to assume %s: %s\n", id, account.name, account.roleARN, errorSummary(err))
+				mu.Lock()
+				skipped = append(skipped, id)
+				mu.Unlock()
+			}
+		}(id, account)
+	}
+	wg.Wait()
+	for _, id := range skipped {
+		delete(orgAccounts, id)
+	}
+
+	for id := range orgAccounts {
+		orgNames = append(orgNames, id)
+	}
+	sort.Slice(orgNames, func(i, j int) bool {
+		if (orgNames[i] == callerAccount) != (orgNames[j] == callerAccount) {
+			return orgNames[i] == callerAccount
+		}
+		return orgNames[i] < orgNames[j]
+	})
+	if len(orgNames) == 0 {
+		fmt.Fprintln(os.Stderr, "no account of the organization can be scanned")
+		os.Exit(1)
+	}
+}
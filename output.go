@@ -0,0 +1,841 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Totals ... usage summed over buckets
+type Totals struct {
+	NumberOfBuckets int                `json:"numberOfBuckets"`
+	NumberOfObjects float64            `json:"numberOfObjects"`
+	TotalSize       float64            `json:"totalSize"`
+	TotalCost       float64            `json:"totalCost"`
+	Sizes           map[string]float64 `json:"sizes"`
+	Costs           map[string]float64 `json:"costs"`
+	// regions ... usage summed per bucket region
+	regions map[string]*RegionTotals
+}
+
+// RegionTotals ... usage summed over buckets of one region
+type RegionTotals struct {
+	Region          string  `json:"region"`
+	NumberOfBuckets int     `json:"numberOfBuckets"`
+	NumberOfObjects float64 `json:"numberOfObjects"`
+	TotalSize       float64 `json:"totalSize"`
+	TotalCost       float64 `json:"totalCost"`
+}
+
+// Add ... accumulate bucket usage
+func (t *Totals) Add(bucket Bucket) {
+	if t.Sizes == nil {
+		t.Sizes = map[string]float64{}
+		t.Costs = map[string]float64{}
+		t.regions = map[string]*RegionTotals{}
+	}
+	region, ok := t.regions[bucket.Region]
+	if !ok {
+		region = &RegionTotals{Region: bucket.Region}
+		t.regions[bucket.Region] = region
+	}
+	region.NumberOfBuckets++
+	region.NumberOfObjects += bucket.NumberOfObjects
+	region.TotalSize += bucket.TotalSize
+	region.TotalCost += bucket.TotalCost
+	t.NumberOfBuckets++
+	t.NumberOfObjects += bucket.NumberOfObjects
+	t.TotalSize += bucket.TotalSize
+	t.TotalCost += bucket.TotalCost
+	for storageType, size := range bucket.Sizes {
+		t.Sizes[storageType] += size
+	}
+	for storageType, cost := range bucket.Costs {
+		t.Costs[storageType] += cost
+	}
+}
+
+// Regions ... totals of each region sorted by region name
+func (t *Totals) Regions() []RegionTotals {
+	regions := []RegionTotals{}
+	for _, region := range t.regions {
+		regions = append(regions, *region)
+	}
+	sort.Slice(regions, func(i, j int) bool { return regions[i].Region < regions[j].Region })
+	return regions
+}
+
+// formatCost ... cost rounded to cents, or in full precision with -exact
+// values are kept exact internally and only rounded here
+func formatCost(cost float64) string {
+	if exact {
+		return strconv.FormatFloat(cost, 'f', -1, 64)
+	}
+	return formatDecimal(cost, 2)
+}
+
+// formatDecimal ... v with decimals digits, rounded or truncated by -rounding
+func formatDecimal(v float64, decimals int) string {
+	if rounding == "trunc" {
+		scale := math.Pow10(decimals)
+		// tolerate float error such as 0.29*100 = 28.999999999999996
+		v = math.Trunc(v*scale+1e-6) / scale
+	}
+	return strconv.FormatFloat(v, 'f', decimals, 64)
+}
+
+// sizeDivisor ... bytes per displayed size unit
+func sizeDivisor() float64 {
+	if rawBytes {
+		return 1
+	}
+	return binaryGB
+}
+
+// sizeLabel ... header of the size column
+func sizeLabel() string {
+	if rawBytes {
+		return "Bytes"
+	}
+	return "GigaBytes"
+}
+
+// formatSize ... gigabytes to two decimals, or whole bytes with -raw-bytes
+func formatSize(size float64) string {
+	if rawBytes {
+		return formatDecimal(size, 0)
+	}
+	return formatDecimal(size, 2)
+}
+
+// aggregateSmall ... buckets in their order with those charged less than -aggregate-small-buckets
+// collapsed into one trailing row, buckets which failed are kept as they are
+func aggregateSmall(buckets []Bucket) []Bucket {
+	if aggregateBelow <= 0 {
+		return buckets
+	}
+	kept := []Bucket{}
+	small := Bucket{Region: "-", Sizes: map[string]float64{}, Costs: map[string]float64{}}
+	count := 0
+	for _, bucket := range buckets {
+		if bucket.Err != nil || bucket.TotalCost >= aggregateBelow {
+			kept = append(kept, bucket)
+			continue
+		}
+		count++
+		small.NumberOfObjects += bucket.NumberOfObjects
+		small.TotalSize += bucket.TotalSize
+		small.TotalCost += bucket.TotalCost
+		for storageType, size := range bucket.Sizes {
+			small.Sizes[storageType] += size
+		}
+		for storageType, cost := range bucket.Costs {
+			small.Costs[storageType] += cost
+		}
+	}
+	if count == 0 {
+		return kept
+	}
+	small.Name = fmt.Sprintf("(%d small buckets)", count)
+	return append(kept, small)
+}
+
+// sortKeys ... keys known to -sort and -sort2, none keeps completion order
+var sortKeys = []string{"name", "cost", "size", "growth", "region", "none"}
+
+// knownSortKey ... whether key is one of sortKeys
+func knownSortKey(key string) bool {
+	for _, known := range sortKeys {
+		if key == known {
+			return true
+		}
+	}
+	return false
+}
+
+// sortBuckets ... sort buffered results by -sort then -sort2, cost, size and growth largest first
+// buckets of unknown growth sort after all others
+// remaining ties are broken by bucket name and profile only with -sort-stable,
+// -sort none leaves them in completion order
+func sortBuckets(buckets []Bucket) {
+	keys := []string{}
+	for _, key := range []string{sortKey, sortKey2} {
+		if key != "" && key != "none" {
+			keys = append(keys, key)
+		}
+	}
+	if sortStable {
+		keys = append(keys, "name")
+	}
+	if len(keys) == 0 {
+		return
+	}
+	less := func(i, j int) bool {
+		for _, key := range keys {
+			if c := compareBuckets(key, buckets[i], buckets[j]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	}
+	if sortStable {
+		sort.SliceStable(buckets, less)
+		return
+	}
+	sort.Slice(buckets, less)
+}
+
+// compareBuckets ... order of a and b by one sort key, negative if a comes first
+func compareBuckets(key string, a, b Bucket) int {
+	switch key {
+	case "cost":
+		return compareDesc(a.TotalCost, b.TotalCost)
+	case "size":
+		return compareDesc(a.TotalSize, b.TotalSize)
+	case "growth":
+		return compareDesc(growthValue(a), growthValue(b))
+	case "region":
+		return strings.Compare(a.Region, b.Region)
+	}
+	if c := strings.Compare(a.Name, b.Name); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Profile, b.Profile)
+}
+
+// compareDesc ... negative if a is larger so larger values come first
+func compareDesc(a, b float64) int {
+	switch {
+	case a > b:
+		return -1
+	case a < b:
+		return 1
+	}
+	return 0
+}
+
+// growthValue ... growth of bucket, unknown growth lowest
+func growthValue(bucket Bucket) float64 {
+	if bucket.Growth == nil {
+		return math.Inf(-1)
+	}
+	return *bucket.Growth
+}
+
+// column ... optional column shown between charges and bucket name
+type column struct {
+	header string
+	width  int
+	value  func(Bucket) string
+}
+
+// extraColumns ... optional columns enabled by flags
+func extraColumns() []column {
+	columns := []column{}
+	if len(aliases) > 0 {
+		columns = append(columns, column{"RawName", 30, func(bucket Bucket) string {
+			return bucket.Name
+		}})
+	}
+	if len(profileNames()) > 1 {
+		columns = append(columns, column{"Profile", 12, func(bucket Bucket) string {
+			return bucket.Profile
+		}})
+	}
+	if created {
+		columns = append(columns, column{"Created", 10, func(bucket Bucket) string {
+			if bucket.CreationDate == nil {
+				return "-"
+			}
+			return bucket.CreationDate.Format("2006-01-02")
+		}})
+	}
+	if activeDays > 0 {
+		columns = append(columns, column{"Active", 6, activeLabel})
+	}
+	if lifecycle {
+		columns = append(columns, column{"Lifecycle", 10, func(bucket Bucket) string {
+			return lifecycleLabel(bucket.LifecycleRules)
+		}})
+	}
+	if versioning {
+		columns = append(columns, column{"Versioning", 10, func(bucket Bucket) string {
+			return bucket.Versioning
+		}})
+	}
+	if requesterPays {
+		columns = append(columns, column{"Payer", 11, func(bucket Bucket) string {
+			return bucket.Payer
+		}})
+	}
+	if replication {
+		columns = append(columns, column{"Replication", 11, func(bucket Bucket) string {
+			return bucket.Replication
+		}})
+	}
+	for _, region := range comparedRegions() {
+		region := region
+		columns = append(columns, column{region, 22, func(bucket Bucket) string {
+			return relocatedCost(bucket, region)
+		}})
+	}
+	if verbose {
+		columns = append(columns, column{"Growth", 8, growthLabel})
+		columns = append(columns, column{"Owner", 64, func(bucket Bucket) string {
+			return bucket.Owner
+		}})
+	}
+	return columns
+}
+
+// activeLabel ... yes if the object count changed over -active-days, no if not and - if unknown
+func activeLabel(bucket Bucket) string {
+	switch {
+	case bucket.ObjectsChange == nil:
+		return "-"
+	case *bucket.ObjectsChange != 0:
+		return "yes"
+	}
+	return "no"
+}
+
+// growthLabel ... size growth since the previous day, - if unknown
+func growthLabel(bucket Bucket) string {
+	if bucket.Growth == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", *bucket.Growth)
+}
+
+// relocatedCost ... cost if bucket were in region and the difference to the current cost
+func relocatedCost(bucket Bucket, region string) string {
+	cost := bucket.RegionCosts[region]
+	diff := cost - bucket.TotalCost
+	sign := "+"
+	if diff < 0 {
+		sign = "-"
+		diff = -diff
+	}
+	return fmt.Sprintf("%s (%s%s)", formatCost(cost), sign, formatCost(diff))
+}
+
+// friendlyNames ... human labels of storage types shown with -friendly-names
+var friendlyNames = map[string]string{
+	"StandardStorage":                "Standard",
+	"IntelligentTieringStorage":      "Intelligent-Tiering",
+	"StandardIAStorage":              "Standard-IA",
+	"StandardIASizeOverhead":         "Standard-IA size overhead",
+	"StandardIAObjectOverhead":       "Standard-IA object overhead",
+	"OneZoneIAStorage":               "One Zone-IA",
+	"OneZoneIASizeOverhead":          "One Zone-IA size overhead",
+	"ReducedRedundancyStorage":       "Reduced Redundancy",
+	"GlacierInstantRetrievalStorage": "Glacier Instant Retrieval",
+	"GlacierIRSizeOverhead":          "Glacier Instant Retrieval size overhead",
+	"GlacierStorage":                 "Glacier Flexible Retrieval",
+	"GlacierStagingStorage":          "Glacier staging (multipart uploads)",
+	"GlacierObjectOverhead":          "Glacier object overhead (32KB per object)",
+	"GlacierS3ObjectOverhead":        "Glacier object index at Standard rate (8KB per object)",
+	"DeepArchiveStorage":             "Glacier Deep Archive",
+	"DeepArchiveObjectOverhead":      "Deep Archive object overhead (32KB per object)",
+	"DeepArchiveS3ObjectOverhead":    "Deep Archive object index at Standard rate (8KB per object)",
+	"DeepArchiveStagingStorage":      "Deep Archive staging (multipart uploads)",
+}
+
+// storageTypeLabel ... storage type as shown in human readable output, raw key unless -friendly-names
+func storageTypeLabel(storageType string) string {
+	if label, ok := friendlyNames[storageType]; ok && friendly {
+		return label
+	}
+	return storageType
+}
+
+// storageGroup ... storage types shown as one line under -v
+type storageGroup struct {
+	label        string
+	storageTypes []string
+}
+
+var storageGroups = []storageGroup{
+	{"Standard-IA (incl. overhead)", []string{"StandardIAStorage", "StandardIASizeOverhead", "StandardIAObjectOverhead"}},
+}
+
+// groupOf ... storage type to the group it is shown in
+var groupOf = map[string]storageGroup{}
+
+func init() {
+	for _, group := range storageGroups {
+		for _, storageType := range group.storageTypes {
+			groupOf[storageType] = group
+		}
+	}
+}
+
+// groupUsage ... combined size and cost of group in bucket
+func groupUsage(bucket Bucket, group storageGroup) (float64, float64) {
+	size, cost := 0.0, 0.0
+	for _, storageType := range group.storageTypes {
+		size += bucket.Sizes[storageType]
+		cost += bucket.Costs[storageType]
+	}
+	return size, cost
+}
+
+// groupPrice ... unit price shared by every storage type of group in bucket, false if they differ
+func groupPrice(bucket Bucket, group storageGroup) (float64, bool) {
+	price, ok := bucket.PricesUsed[group.storageTypes[0]]
+	for _, storageType := range group.storageTypes[1:] {
+		if other, found := bucket.PricesUsed[storageType]; !found || other != price {
+			return 0, false
+		}
+	}
+	return price, ok
+}
+
+// archiveAdvisoryGB ... archive size from which the retrieval charges advisory is shown
+const archiveAdvisoryGB = 1.0
+
+// archiveSize ... size stored in Glacier and Deep Archive storage types
+func archiveSize(bucket Bucket) float64 {
+	size := 0.0
+	for storageType, typeSize := range bucket.Sizes {
+		if strings.HasPrefix(storageType, "Glacier") || strings.HasPrefix(storageType, "DeepArchive") {
+			size += typeSize
+		}
+	}
+	return size
+}
+
+// printFlat ... one line per bucket and non-zero storage type for awk/sort/uniq
+func printFlat(buckets []Bucket) {
+	for _, bucket := range buckets {
+		for _, storageType := range bucket.pricedTypes() {
+			if bucket.Sizes[storageType] != 0.0 {
+				fmt.Printf("%s %s %s %s\n",
+					bucket.Name,
+					storageType,
+					formatSize(bucket.Sizes[storageType]),
+					formatCost(bucket.Costs[storageType]))
+			}
+		}
+		if cost := bucket.Costs[itMonitoringCost]; cost != 0 {
+			fmt.Printf("%s %s %s %s\n", bucket.Name, itMonitoringCost, formatSize(0), formatCost(cost))
+		}
+	}
+}
+
+// printTotals ... grand total only, in the selected output format
+func printTotals(totals Totals) {
+	switch output {
+	case "table":
+		fmt.Printf("%12s %14s %14s  Buckets\n", "ObjectCount", sizeLabel(), "Charges-USD")
+		fmt.Printf("%12d %14s %14s  %d\n",
+			int(totals.NumberOfObjects),
+			formatSize(totals.TotalSize),
+			formatCost(totals.TotalCost),
+			totals.NumberOfBuckets)
+	case "json":
+		if regionTotals {
+			exitOnError(printJSONValue(os.Stdout, struct {
+				reportLabel
+				Totals  Totals         `json:"totals"`
+				Regions []RegionTotals `json:"regions"`
+			}{newReportLabel(), totals, totals.Regions()}))
+			break
+		}
+		exitOnError(printJSONValue(os.Stdout, struct {
+			reportLabel
+			Totals Totals `json:"totals"`
+		}{newReportLabel(), totals}))
+	case "markdown":
+		writeMarkdownTitle(os.Stdout)
+		fmt.Printf("| Buckets | ObjectCount | %s | Charges-USD |\n", sizeLabel())
+		fmt.Println("|---:|---:|---:|---:|")
+		fmt.Printf("| %d | %d | %s | %s |\n",
+			totals.NumberOfBuckets,
+			int(totals.NumberOfObjects),
+			formatSize(totals.TotalSize),
+			formatCost(totals.TotalCost))
+	}
+}
+
+// redirectOutput ... point stdout, which every printer writes to, at the -out file replaced or with appending
+// extended by a new block headed by the run's start time, json and -template reports, e.g. csv rows,
+// follow each other without a heading, with compressing the file is written through gzip and each run
+// appends one gzip member, the report is written to a temporary file next to path, an appended one
+// starting as a copy of it, the returned func flushes and closes it and with keep renames it onto path,
+// otherwise removes it so a failed run leaves path as it was
+func redirectOutput(path string, appending, compressing bool) (func(keep bool), error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return nil, fmt.Errorf("unable to open output file %s: %v", path, err)
+	}
+	fail := func(err error) (func(bool), error) {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("unable to open output file %s: %v", path, err)
+	}
+	mode := os.FileMode(0644)
+	continued := false
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+		if appending {
+			old, err := os.Open(path)
+			if err != nil {
+				return fail(err)
+			}
+			n, err := io.Copy(f, old)
+			old.Close()
+			if err != nil {
+				return fail(err)
+			}
+			continued = n > 0
+		}
+	}
+	if err := f.Chmod(mode); err != nil {
+		return fail(err)
+	}
+	// finish ... flush and close f, then keep or drop it
+	finish := func(keep bool, flush func() error) {
+		err := flush()
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if keep && err == nil {
+			err = os.Rename(f.Name(), path)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to write output file %s: %v\n", path, err)
+		}
+		if !keep || err != nil {
+			os.Remove(f.Name())
+		}
+	}
+	closeOutput := func(keep bool) { finish(keep, func() error { return nil }) }
+	out := f
+	if compressing {
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			return fail(err)
+		}
+		done := make(chan error)
+		go func() {
+			zw := gzip.NewWriter(f)
+			_, err := io.Copy(zw, pr)
+			if closeErr := zw.Close(); err == nil {
+				err = closeErr
+			}
+			done <- err
+		}()
+		out = pw
+		closeOutput = func(keep bool) {
+			finish(keep, func() error {
+				pw.Close()
+				return <-done
+			})
+		}
+	}
+	os.Stdout = out
+	closeOutput = closeOnce(closeOutput)
+	if !appending || jsonOutput() || templateText != "" {
+		return closeOutput, nil
+	}
+	heading := "# s3usage run at "
+	if output == "markdown" {
+		heading = "### s3usage run at "
+	}
+	if continued {
+		heading = "\n" + heading
+	}
+	fmt.Printf("%s%s\n\n", heading, startedAt.Format(time.RFC3339))
+	return closeOutput, nil
+}
+
+// closeOnce ... closeOutput running only on its first call, exit paths may reach it after main did
+func closeOnce(closeOutput func(keep bool)) func(keep bool) {
+	var o sync.Once
+	return func(keep bool) {
+		o.Do(func() { closeOutput(keep) })
+	}
+}
+
+// closeOutput ... flush and close the -out file, keeping it only when the report was complete,
+// nothing without -out
+var closeOutput = func(keep bool) {}
+
+// exit ... exit with code after dropping an unfinished -out file, for failures once output is redirected
+func exit(code int) {
+	closeOutput(false)
+	os.Exit(code)
+}
+
+// jsonOutput ... whether stdout carries a single json document, -o json or -o grafana,
+// which no other line may be written into
+func jsonOutput() bool {
+	return output == "json" || output == "grafana"
+}
+
+func printJSONValue(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("unable to encode json: %v", err)
+	}
+	return nil
+}
+
+// bucketError ... bucket which could not be measured
+type bucketError struct {
+	Name    string `json:"name"`
+	Region  string `json:"region"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func newBucketError(bucket Bucket) bucketError {
+	bucketErr := bucketError{
+		Name:    bucket.Name,
+		Region:  bucket.Region,
+		Message: bucket.Err.Error(),
+	}
+	if aerr, ok := bucket.Err.(awserr.Error); ok {
+		bucketErr.Code = aerr.Code()
+		bucketErr.Message = aerr.Message()
+	}
+	return bucketErr
+}
+
+// failedBuckets ... buckets whose region or metrics could not be fetched
+func failedBuckets(buckets []Bucket) []Bucket {
+	failed := []Bucket{}
+	for _, bucket := range buckets {
+		if bucket.Err != nil {
+			failed = append(failed, bucket)
+		}
+	}
+	return failed
+}
+
+// printErrors ... buckets whose region or metrics could not be fetched
+func printErrors(buckets []Bucket) {
+	errs := []bucketError{}
+	for _, bucket := range buckets {
+		errs = append(errs, newBucketError(bucket))
+	}
+	switch output {
+	case "table":
+		for _, bucketErr := range errs {
+			fmt.Printf("%s (%s)  %s: %s\n", bucketErr.Name, bucketErr.Region, bucketErr.Code, bucketErr.Message)
+		}
+	case "json":
+		exitOnError(printJSONValue(os.Stdout, struct {
+			Errors []bucketError `json:"errors"`
+		}{errs}))
+	case "markdown":
+		fmt.Println("| BucketName | Region | Code | Message |")
+		fmt.Println("|---|---|---|---|")
+		for _, bucketErr := range errs {
+			fmt.Printf("| %s | %s | %s | %s |\n", bucketErr.Name, bucketErr.Region, bucketErr.Code, bucketErr.Message)
+		}
+	}
+}
+
+// printStorageClassSummary ... size and cost of each storage type summed over all buckets, largest cost first
+// json output already carries these in totals
+func printStorageClassSummary(totals Totals) {
+	types := summaryTypes(totals)
+	switch output {
+	case "table":
+		fmt.Println()
+		fmt.Printf("%15s %14s  StorageType\n", sizeLabel(), "Charges-USD")
+		for _, storageType := range types {
+			fmt.Printf("%15s %14s  %s\n",
+				formatSize(totals.Sizes[storageType]),
+				formatCost(totals.Costs[storageType]),
+				storageTypeLabel(storageType))
+		}
+	case "markdown":
+		fmt.Println()
+		fmt.Printf("| StorageType | %s | Charges-USD |\n", sizeLabel())
+		fmt.Println("|---|---:|---:|")
+		for _, storageType := range types {
+			fmt.Printf("| %s | %s | %s |\n",
+				storageTypeLabel(storageType),
+				formatSize(totals.Sizes[storageType]),
+				formatCost(totals.Costs[storageType]))
+		}
+	}
+}
+
+// summaryTypes ... storage types with size or cost in totals, cost descending and ties in name order
+func summaryTypes(totals Totals) []string {
+	types := []string{}
+	for _, storageType := range sortedKeys(totals.Costs) {
+		if totals.Sizes[storageType] != 0.0 || totals.Costs[storageType] != 0.0 {
+			types = append(types, storageType)
+		}
+	}
+	sort.SliceStable(types, func(i, j int) bool {
+		return totals.Costs[types[i]] > totals.Costs[types[j]]
+	})
+	return types
+}
+
+// printIdentities ... account id and arn of each profile so shared reports show where they come from
+// written to stderr with json to keep stdout a single document
+func printIdentities(names []string, identities map[string]*sts.GetCallerIdentityOutput) {
+	w := os.Stdout
+	if jsonOutput() || sumOnlyCost {
+		w = os.Stderr
+	}
+	for _, name := range names {
+		identity := identities[name]
+		fmt.Fprintf(w, "Account: %s  Arn: %s  (profile %s)\n", aws.StringValue(identity.Account), aws.StringValue(identity.Arn), name)
+	}
+	if w == os.Stdout {
+		fmt.Fprintln(w)
+	}
+}
+
+// printInterrupted ... note that the report only covers buckets completed before interrupt
+func printInterrupted(totals Totals) {
+	fmt.Fprintf(os.Stderr, "interrupted: partial total of %d completed buckets, %d objects, %s %s, %s USD\n",
+		totals.NumberOfBuckets,
+		int(totals.NumberOfObjects),
+		formatSize(totals.TotalSize),
+		sizeLabel(),
+		formatCost(totals.TotalCost))
+}
+
+// printProjection ... explain that charges project the current size over a month
+func printProjection() {
+	days := currentMonthDays()
+	if daysInMonth > 0 {
+		fmt.Fprintf(os.Stderr, "charges are projected for %d of %d days this month at the current size\n", daysInMonth, days)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "charges are a full month (%d days) projection at the current size, not the month-to-date bill\n", days)
+}
+
+// printGrowthWarnings ... warn of buckets whose size grew more than -growth-threshold percent in a day
+func printGrowthWarnings(buckets []Bucket) {
+	for _, bucket := range buckets {
+		if bucket.Growth != nil && *bucket.Growth > growthThreshold {
+			fmt.Fprintf(os.Stderr, "bucket %s grew %+.1f%% in a day (threshold %g%%)\n", bucket.Name, *bucket.Growth, growthThreshold)
+		}
+	}
+}
+
+// iaClasses ... infrequent access storage with the size metric of the 128KB per object minimum it is billed for
+var iaClasses = []struct {
+	label    string
+	storage  []string
+	overhead string
+}{
+	{"Standard-IA", []string{"StandardIAStorage", "StandardIAObjectOverhead"}, "StandardIASizeOverhead"},
+	{"One Zone-IA", []string{"OneZoneIAStorage"}, "OneZoneIASizeOverhead"},
+	{"Glacier Instant Retrieval", []string{"GlacierInstantRetrievalStorage"}, "GlacierIRSizeOverhead"},
+}
+
+// printIAOverheadWarnings ... warn of buckets whose infrequent access size is more than -ia-overhead-threshold
+// percent padding of objects smaller than 128KB, such objects are cheaper kept in Standard
+func printIAOverheadWarnings(buckets []Bucket) {
+	for _, bucket := range buckets {
+		for _, class := range iaClasses {
+			overhead := bucket.Sizes[class.overhead]
+			total := overhead
+			for _, storageType := range class.storage {
+				total += bucket.Sizes[storageType]
+			}
+			if total == 0 {
+				continue
+			}
+			if share := overhead / total * 100; share > iaOverheadThreshold {
+				fmt.Fprintf(os.Stderr, "bucket %s: %.1f%% of %s is the 128KB minimum object size overhead (threshold %g%%), its small objects may be cheaper in Standard\n",
+					bucket.Name, share, class.label, iaOverheadThreshold)
+			}
+		}
+	}
+}
+
+// printMissingMetrics ... list buckets without any size datapoint to stderr, whether there were some
+func printMissingMetrics(buckets []Bucket) bool {
+	missing := 0
+	for _, bucket := range buckets {
+		if bucket.NoData {
+			fmt.Fprintf(os.Stderr, "bucket %s (%s) has no metrics\n", bucket.Name, bucket.Region)
+			missing++
+		}
+	}
+	if missing > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d buckets have no metrics\n", missing, len(buckets))
+	}
+	return missing > 0
+}
+
+// field ... bucket attribute selectable with -fields
+type field struct {
+	name   string
+	header string
+	width  int
+	text   func(Bucket) string
+	value  func(Bucket) interface{}
+}
+
+// knownFields ... attributes known to -fields, text is shown in table and markdown, value in json
+func knownFields() []field {
+	return []field{
+		{"name", "BucketName", 40, func(b Bucket) string { return b.Name }, func(b Bucket) interface{} { return b.Name }},
+		{"alias", "Alias", 30, displayName, func(b Bucket) interface{} { return displayName(b) }},
+		{"profile", "Profile", 12, func(b Bucket) string { return b.Profile }, func(b Bucket) interface{} { return b.Profile }},
+		{"region", "Region", 14, func(b Bucket) string { return b.Region }, func(b Bucket) interface{} { return b.Region }},
+		{"objects", "ObjectCount", 12, func(b Bucket) string { return strconv.Itoa(int(b.NumberOfObjects)) }, func(b Bucket) interface{} { return b.NumberOfObjects }},
+		{"size", sizeLabel(), 14, func(b Bucket) string { return emptyOr(b, formatSize(b.TotalSize)) }, func(b Bucket) interface{} { return b.TotalSize }},
+		{"cost", "Charges-USD", 14, func(b Bucket) string { return emptyOr(b, formatCost(b.TotalCost)) }, func(b Bucket) interface{} { return b.TotalCost }},
+		{"created", "Created", 10, func(b Bucket) string {
+			if b.CreationDate == nil {
+				return "-"
+			}
+			return b.CreationDate.Format("2006-01-02")
+		}, func(b Bucket) interface{} { return b.CreationDate }},
+		{"lifecycle", "Lifecycle", 10, func(b Bucket) string { return lifecycleLabel(b.LifecycleRules) }, func(b Bucket) interface{} { return b.LifecycleRules }},
+		{"versioning", "Versioning", 10, func(b Bucket) string { return b.Versioning }, func(b Bucket) interface{} { return b.Versioning }},
+		{"payer", "Payer", 11, func(b Bucket) string { return b.Payer }, func(b Bucket) interface{} { return b.Payer }},
+		{"replication", "Replication", 11, func(b Bucket) string { return b.Replication }, func(b Bucket) interface{} { return b.Replication }},
+		{"owner", "Owner", 64, func(b Bucket) string { return b.Owner }, func(b Bucket) interface{} { return b.Owner }},
+		{"active", "Active", 6, activeLabel, func(b Bucket) interface{} { return b.ObjectsChange }},
+		{"growth", "Growth", 8, growthLabel, func(b Bucket) interface{} { return b.Growth }},
+	}
+}
+
+// selectedFields ... fields of -fields in the given order, error on an unknown name
+func selectedFields() ([]field, error) {
+	known := map[string]field{}
+	names := []string{}
+	for _, f := range knownFields() {
+		known[f.name] = f
+		names = append(names, f.name)
+	}
+	selected := []field{}
+	for _, name := range splitList(fieldNames) {
+		f, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q in -fields, known fields are %s", name, strings.Join(names, ","))
+		}
+		selected = append(selected, f)
+	}
+	return selected, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSortBuckets(t *testing.T) {
+	completed := []Bucket{
+		{Name: "c", TotalCost: 1},
+		{Name: "a", TotalCost: 2},
+		{Name: "d", TotalCost: 1},
+		{Name: "b", TotalCost: 1},
+	}
+	reversed := []Bucket{}
+	for i := len(completed) - 1; i >= 0; i-- {
+		reversed = append(reversed, completed[i])
+	}
+	tests := []struct {
+		name   string
+		key    string
+		stable bool
+		input  []Bucket
+		want   []string
+	}{
+		{"default name order", "name", false, completed, []string{"a", "b", "c", "d"}},
+		{"name order of any completion order", "name", false, reversed, []string{"a", "b", "c", "d"}},
+		{"stable cost", "cost", true, completed, []string{"a", "b", "c", "d"}},
+		{"stable cost of any completion order", "cost", true, reversed, []string{"a", "b", "c", "d"}},
+		{"none keeps completion order", "none", false, completed, []string{"c", "a", "d", "b"}},
+	}
+	savedKey, savedKey2, savedStable := sortKey, sortKey2, sortStable
+	defer func() { sortKey, sortKey2, sortStable = savedKey, savedKey2, savedStable }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sortKey, sortKey2, sortStable = tt.key, "", tt.stable
+			buckets := append([]Bucket{}, tt.input...)
+			sortBuckets(buckets)
+			got := []string{}
+			for _, bucket := range buckets {
+				got = append(got, bucket.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("sortBuckets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppendHeading(t *testing.T) {
+	savedOutput, savedStdout := output, os.Stdout
+	defer func() { output, os.Stdout = savedOutput, savedStdout }()
+	dir, err := ioutil.TempDir("", "s3usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for format, headed := range map[string]bool{"table": true, "markdown": true, "json": false, "grafana": false} {
+		output = format
+		path := filepath.Join(dir, format)
+		closeOutput, err := redirectOutput(path, true, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Println("report")
+		closeOutput(true)
+		os.Stdout = savedStdout
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(string(data), "s3usage run at"); got != headed {
+			t.Errorf("-o %s -append heading written = %v, want %v: %q", format, got, headed, data)
+		}
+	}
+}
+
+func TestPrintFlatSumsToTotalCost(t *testing.T) {
+	savedExact, savedRate, savedStdout := exact, itMonitoringRate, os.Stdout
+	defer func() { exact, itMonitoringRate, os.Stdout = savedExact, savedRate, savedStdout }()
+	exact, itMonitoringRate = true, 0.0025
+	// a storage type discovered after the price table, priced by the prefix it shares with StandardStorage
+	bucket := Bucket{Name: "bucket", Region: defaultRegion, NumberOfObjects: 10000, types: []string{"StandardStorage", "StandardStorageNext"}}
+	applySizes(&bucket, map[string]float64{
+		"StandardStorage":           binaryGB,
+		"StandardStorageNext":       2 * binaryGB,
+		"IntelligentTieringStorage": binaryGB,
+	}, nil)
+
+	f, err := ioutil.TempFile("", "s3usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	os.Stdout = f
+	printFlat([]Bucket{bucket})
+	os.Stdout = savedStdout
+	f.Close()
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sum, seen := 0.0, map[string]bool{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			t.Fatalf("line %q has %d fields, want 4", line, len(fields))
+		}
+		cost, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[fields[1]] = true
+		sum += cost
+	}
+	for _, storageType := range []string{"StandardStorage", "StandardStorageNext", "IntelligentTieringStorage", itMonitoringCost} {
+		if !seen[storageType] {
+			t.Errorf("no %s line in %q", storageType, data)
+		}
+	}
+	if !almostEqual(sum, bucket.TotalCost) {
+		t.Errorf("flattened costs sum to %v, TotalCost = %v", sum, bucket.TotalCost)
+	}
+}
+
+func TestRedirectOutputOnlyReplacesFinishedReports(t *testing.T) {
+	savedOutput, savedStdout := output, os.Stdout
+	defer func() { output, os.Stdout = savedOutput, savedStdout }()
+	output = "table"
+	dir, err := ioutil.TempDir("", "s3usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name                   string
+		existing               string
+		appending, compressing bool
+		keep                   bool
+	}{
+		{"replaced", "old report\n", false, false, true},
+		{"failed replace", "old report\n", false, false, false},
+		{"appended", "old report\n", true, false, true},
+		{"failed append", "old report\n", true, false, false},
+		{"failed gzip", "", false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.Replace(tt.name, " ", "-", -1))
+			if tt.existing != "" {
+				if err := ioutil.WriteFile(path, []byte(tt.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			closeOutput, err := redirectOutput(path, tt.appending, tt.compressing)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fmt.Println("new report")
+			closeOutput(tt.keep)
+			// a second call, as from an exit path after main closed the output, changes nothing
+			closeOutput(!tt.keep)
+			os.Stdout = savedStdout
+
+			data, err := ioutil.ReadFile(path)
+			switch {
+			case !tt.keep && tt.existing == "":
+				if !os.IsNotExist(err) {
+					t.Errorf("%s was created by a failed run: %q, %v", path, data, err)
+				}
+			case !tt.keep:
+				if string(data) != tt.existing {
+					t.Errorf("%s = %q after a failed run, want it untouched %q", path, data, tt.existing)
+				}
+			case tt.appending:
+				if !strings.HasPrefix(string(data), tt.existing) || !strings.HasSuffix(string(data), "new report\n") {
+					t.Errorf("%s = %q, want %q followed by the new report", path, data, tt.existing)
+				}
+			default:
+				if string(data) != "new report\n" {
+					t.Errorf("%s = %q, want the new report", path, data)
+				}
+			}
+		})
+	}
+	leftover, err := filepath.Glob(filepath.Join(dir, ".*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leftover) > 0 {
+		t.Errorf("temporary files left behind: %v", leftover)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// PriceProvider ... unit price in USD per GB-month of storageType in region
+type PriceProvider interface {
+	Price(region, storageType string) (float64, bool)
+}
+
+// mapPrices ... same prices in every region, used for costDef and pricing files
+type mapPrices map[string]float64
+
+func (p mapPrices) Price(region, storageType string) (float64, bool) {
+	price, ok := p[storageType]
+	return price, ok
+}
+
+// regionPrices ... prices of a pricing file, regions without their own table use fallback
+type regionPrices struct {
+	tables   map[string]mapPrices
+	fallback mapPrices
+	builtin  bool // fallback is costDef as the file had no top level prices
+}
+
+func (p regionPrices) Price(region, storageType string) (float64, bool) {
+	if table, ok := p.tables[region]; ok {
+		if price, ok := table[storageType]; ok {
+			return price, true
+		}
+	}
+	return p.fallback.Price(region, storageType)
+}
+
+// storageTypes ... storage types priced in any table in name order
+func (p regionPrices) storageTypes() []string {
+	all := map[string]float64{}
+	for storageType, price := range p.fallback {
+		all[storageType] = price
+	}
+	for _, table := range p.tables {
+		for storageType, price := range table {
+			all[storageType] = price
+		}
+	}
+	return sortedKeys(all)
+}
+
+// ComputeCost ... total and per storage type cost of sizes in GB at prices in USD per GB-month,
+// storage types missing from prices cost nothing
+func ComputeCost(sizes map[string]float64, prices map[string]float64) (float64, map[string]float64) {
+	total := 0.0
+	perType := map[string]float64{}
+	for _, storageType := range sortedKeys(sizes) {
+		cost := sizes[storageType] * prices[storageType]
+		perType[storageType] = cost
+		total += cost
+	}
+	return total, perType
+}
+
+// fuzzyWarned ... storage types already warned about by fuzzyPrice
+var fuzzyWarned sync.Map
+
+// fuzzyPrice ... price in region of the longest scanned storage type which storageType starts with,
+// for storage types newer than the price table under -fuzzy-pricing, warns once per storage type
+func fuzzyPrice(region, storageType string) (float64, bool) {
+	match := ""
+	for _, known := range storageTypes {
+		if strings.HasPrefix(storageType, known) && len(known) > len(match) {
+			match = known
+		}
+	}
+	if match == "" {
+		if _, warned := fuzzyWarned.LoadOrStore(storageType, true); !warned {
+			fmt.Fprintf(os.Stderr, "storage type %s has no price and matches no known storage type, it is not charged\n", storageType)
+		}
+		return 0, false
+	}
+	if _, warned := fuzzyWarned.LoadOrStore(storageType, true); !warned {
+		fmt.Fprintf(os.Stderr, "storage type %s has no price, using the price of %s\n", storageType, match)
+	}
+	return prices.Price(region, match)
+}
+
+// knownStorageType ... whether storageType is one of the scanned storage types
+func knownStorageType(storageType string) bool {
+	for _, known := range storageTypes {
+		if known == storageType {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedTypes ... keys of a storage type set in name order
+func sortedTypes(types map[string]bool) []string {
+	keys := []string{}
+	for key := range types {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// itMonitoringCost ... key of the Intelligent-Tiering monitoring and automation fee in a bucket's costs
+const itMonitoringCost = "IntelligentTieringMonitoring"
+
+// itMonitoringFee ... monthly Intelligent-Tiering monitoring fee at -it-monitoring-rate USD per 1,000 objects,
+// cloudwatch only counts objects over all storage types so the Intelligent-Tiering objects are
+// estimated by their share of the bucket's bytes, objects under 128KB are not exempted
+func itMonitoringFee(count float64, sizeBytes map[string]float64) float64 {
+	if itMonitoringRate <= 0 {
+		return 0
+	}
+	total := 0.0
+	for _, tmpBytes := range sizeBytes {
+		total += tmpBytes
+	}
+	if total == 0 {
+		return 0
+	}
+	objects := count * sizeBytes["IntelligentTieringStorage"] / total
+	return objects / 1000 * itMonitoringRate * monthFactor()
+}
+
+// priceTable ... prices of the scanned storage types in region
+func priceTable(region string) map[string]float64 {
+	table := map[string]float64{}
+	for _, storageType := range storageTypes {
+		if price, ok := prices.Price(region, storageType); ok {
+			table[storageType] = price
+		}
+	}
+	return table
+}
+
+// excludeStorageTypes ... types without excluded ones, warns of excluded names not in types
+func excludeStorageTypes(types, excluded []string) []string {
+	skip := map[string]bool{}
+	for _, storageType := range excluded {
+		skip[storageType] = true
+	}
+	kept := []string{}
+	for _, storageType := range types {
+		if skip[storageType] {
+			delete(skip, storageType)
+			continue
+		}
+		kept = append(kept, storageType)
+	}
+	for _, storageType := range excluded {
+		if skip[storageType] {
+			fmt.Fprintf(os.Stderr, "unknown storage type %s in -exclude-storage-types\n", storageType)
+		}
+	}
+	return kept
+}
+
+// loadPriceFile ... prices from a json file of storage type to USD per GB-month,
+// objects keyed by region name hold per region tables, e.g.
+// {"StandardStorage": 0.025, "us-east-1": {"StandardStorage": 0.023}}
+// without top level prices, regions missing a table fall back to built-in prices
+// path may be a local file, s3://bucket/key or - for stdin
+func loadPriceFile(path string) (regionPrices, error) {
+	prices := regionPrices{tables: map[string]mapPrices{}, fallback: mapPrices{}}
+	data, err := readPricingSource(path)
+	if err != nil {
+		return prices, err
+	}
+	if path == "-" {
+		path = "from stdin"
+		if len(bytes.TrimSpace(data)) == 0 {
+			return prices, fmt.Errorf("unable to parse pricing file %s: no input", path)
+		}
+	}
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return prices, fmt.Errorf("unable to parse pricing file %s: %v", path, err)
+	}
+	for key, value := range raw {
+		var price float64
+		if err := json.Unmarshal(value, &price); err == nil {
+			if price < 0 {
+				return prices, fmt.Errorf("unable to parse pricing file %s at %s: negative price %v", path, key, price)
+			}
+			prices.fallback[key] = price
+			continue
+		}
+		table := mapPrices{}
+		if err := json.Unmarshal(value, &table); err != nil {
+			return prices, fmt.Errorf("unable to parse pricing file %s at %s: %v", path, key, err)
+		}
+		for storageType, price := range table {
+			if price < 0 {
+				return prices, fmt.Errorf("unable to parse pricing file %s at %s.%s: negative price %v", path, key, storageType, price)
+			}
+		}
+		prices.tables[key] = table
+	}
+	if len(prices.fallback) == 0 {
+		prices.fallback = mapPrices(costDef)
+		prices.builtin = true
+	}
+	return prices, nil
+}
+
+// printPricingFallbacks ... warn of scanned regions priced by the fallback of a pricing file with
+// per region tables, wholly when the region has no table or for the types its table leaves out
+func printPricingFallbacks(buckets []Bucket) {
+	filePrices, ok := prices.(regionPrices)
+	if !ok || len(filePrices.tables) == 0 {
+		return
+	}
+	fallback := "top level prices of " + pricingFile
+	if filePrices.builtin {
+		fallback = "built-in tokyo prices"
+	}
+	seen := map[string]bool{}
+	regions := []string{}
+	for _, bucket := range buckets {
+		if bucket.Err == nil && bucket.Region != "" && !seen[bucket.Region] {
+			seen[bucket.Region] = true
+			regions = append(regions, bucket.Region)
+		}
+	}
+	sort.Strings(regions)
+	for _, region := range regions {
+		table, ok := filePrices.tables[region]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "no pricing table for region %s, its costs are approximate from %s\n", region, fallback)
+			continue
+		}
+		missing := []string{}
+		for _, storageType := range storageTypes {
+			if _, ok := table[storageType]; !ok {
+				if _, ok := filePrices.fallback[storageType]; ok {
+					missing = append(missing, storageType)
+				}
+			}
+		}
+		if len(missing) > 0 {
+			fmt.Fprintf(os.Stderr, "pricing table for region %s lacks %s, priced from %s\n", region, strings.Join(missing, ","), fallback)
+		}
+	}
+}
+
+// readPricingSource ... contents of a local pricing file, an s3://bucket/key object or stdin for -
+func readPricingSource(path string) ([]byte, error) {
+	if path == "-" {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read pricing file from stdin: %v", err)
+		}
+		return data, nil
+	}
+	if !strings.HasPrefix(path, "s3://") {
+		return ioutil.ReadFile(path)
+	}
+	location := strings.SplitN(strings.TrimPrefix(path, "s3://"), "/", 2)
+	if len(location) != 2 || location[0] == "" || location[1] == "" {
+		return nil, fmt.Errorf("invalid pricing location %s, expected s3://bucket/key", path)
+	}
+	clients := newAWSClients(profileNames()[0])
+	region, err := clients.BucketRegion(location[0])
+	if err != nil {
+		return nil, fmt.Errorf("unable to find pricing bucket %s's region: %v", location[0], err)
+	}
+	resp, err := clients.s3Client(region).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(location[0]),
+		Key:    aws.String(location[1]),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch pricing file %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read pricing file %s: %v", path, err)
+	}
+	return data, nil
+}
+
+// sortedKeys ... storage types of prices in name order
+func sortedKeys(prices map[string]float64) []string {
+	keys := []string{}
+	for key := range prices {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestComputeCost(t *testing.T) {
+	prices := map[string]float64{
+		"StandardStorage":   0.025,
+		"StandardIAStorage": 0.019,
+		"GlacierStorage":    0.005,
+	}
+	tests := []struct {
+		name    string
+		sizes   map[string]float64
+		total   float64
+		perType map[string]float64
+	}{
+		{"nothing stored", map[string]float64{}, 0, map[string]float64{}},
+		{"standard only", map[string]float64{"StandardStorage": 100}, 2.5, map[string]float64{"StandardStorage": 2.5}},
+		{"mixed", map[string]float64{"StandardStorage": 100, "StandardIAStorage": 200, "GlacierStorage": 1000},
+			2.5 + 3.8 + 5, map[string]float64{"StandardStorage": 2.5, "StandardIAStorage": 3.8, "GlacierStorage": 5}},
+		{"zero size", map[string]float64{"StandardStorage": 0, "GlacierStorage": 10}, 0.05,
+			map[string]float64{"StandardStorage": 0, "GlacierStorage": 0.05}},
+		{"unknown type costs nothing", map[string]float64{"StandardStorage": 100, "NewShinyStorage": 500},
+			2.5, map[string]float64{"StandardStorage": 2.5, "NewShinyStorage": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			total, perType := ComputeCost(tt.sizes, prices)
+			if !almostEqual(total, tt.total) {
+				t.Errorf("total = %v, want %v", total, tt.total)
+			}
+			if len(perType) != len(tt.perType) {
+				t.Errorf("perType = %v, want %v", perType, tt.perType)
+			}
+			for storageType, want := range tt.perType {
+				if got, ok := perType[storageType]; !ok || !almostEqual(got, want) {
+					t.Errorf("perType[%s] = %v, want %v", storageType, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestITMonitoringFee(t *testing.T) {
+	tests := []struct {
+		name      string
+		rate      float64
+		count     float64
+		sizeBytes map[string]float64
+		want      float64
+	}{
+		{"disabled", 0, 10000, map[string]float64{"IntelligentTieringStorage": binaryGB}, 0},
+		{"empty bucket", 0.0025, 0, map[string]float64{}, 0},
+		{"no intelligent-tiering", 0.0025, 10000, map[string]float64{"StandardStorage": binaryGB}, 0},
+		{"all intelligent-tiering", 0.0025, 10000, map[string]float64{"IntelligentTieringStorage": binaryGB}, 0.025},
+		{"share by bytes", 0.0025, 10000, map[string]float64{"IntelligentTieringStorage": binaryGB, "StandardStorage": 3 * binaryGB}, 0.00625},
+	}
+	defer func(saved float64) { itMonitoringRate = saved }(itMonitoringRate)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			itMonitoringRate = tt.rate
+			if got := itMonitoringFee(tt.count, tt.sizeBytes); !almostEqual(got, tt.want) {
+				t.Errorf("itMonitoringFee() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplySizesITMonitoring(t *testing.T) {
+	defer func(saved float64) { itMonitoringRate = saved }(itMonitoringRate)
+	itMonitoringRate = 0.0025
+	bucket := Bucket{Region: defaultRegion, NumberOfObjects: 10000}
+	applySizes(&bucket, map[string]float64{"IntelligentTieringStorage": binaryGB}, nil)
+	if got := bucket.Costs[itMonitoringCost]; !almostEqual(got, 0.025) {
+		t.Errorf("Costs[%s] = %v, want 0.025", itMonitoringCost, got)
+	}
+	if want := costDef["IntelligentTieringStorage"] + 0.025; !almostEqual(bucket.TotalCost, want) {
+		t.Errorf("TotalCost = %v, want %v", bucket.TotalCost, want)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// target ... buckets of one profile ready to scan
+type target struct {
+	clients *awsClients
+	buckets []Bucket
+}
+
+// profileNames ... profiles to scan, -profiles if given otherwise -p
+// which may also be a comma separated list like -profiles, or the account ids of -org once resolved
+func profileNames() []string {
+	if orgNames != nil {
+		return orgNames
+	}
+	if profiles != "" {
+		return splitList(profiles)
+	}
+	if strings.Contains(profile, ",") {
+		return splitList(profile)
+	}
+	return []string{profile}
+}
+
+// profileRegion ... default region of profile used to list buckets and look up their regions,
+// -profile-region-map entry if any otherwise defaultRegion
+func profileRegion(name string) string {
+	for _, pair := range splitList(profileRegionMap) {
+		if kv := strings.SplitN(pair, "=", 2); len(kv) == 2 && strings.TrimSpace(kv[0]) == name {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	return defaultRegion
+}
+
+// checkCredentials ... caller identity of each profile, exits before scanning when credentials
+// of any profile are missing, invalid or expired instead of printing an empty report
+func checkCredentials(names []string) map[string]*sts.GetCallerIdentityOutput {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	identities := map[string]*sts.GetCallerIdentityOutput{}
+	failed := false
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			identity, err := newAWSClients(name).CallerIdentity()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "credentials of profile %s are missing, invalid or expired: %s\n", name, errorSummary(err))
+				failed = true
+				return
+			}
+			identities[name] = identity
+		}(name)
+	}
+	wg.Wait()
+	if failed {
+		os.Exit(1)
+	}
+	return identities
+}
+
+// prepareTargets ... list buckets and resolve their regions of each profile concurrently
+func prepareTargets(names []string) []target {
+	var wg sync.WaitGroup
+
+	targets := make([]target, len(names))
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			clients := newAWSClients(name)
+			listed := listBuckets(clients.S3(clients.region))
+			bucketNames := []string{}
+			for _, b := range listed {
+				bucketNames = append(bucketNames, aws.StringValue(b.Name))
+			}
+			regions, regionErrs := map[string]string{}, map[string]error{}
+			if noRegionLookup {
+				for _, bucketName := range bucketNames {
+					regions[bucketName] = clients.region
+				}
+			} else {
+				regions, regionErrs = resolveRegions(clients, bucketNames)
+			}
+			prewarmClients(clients, regions)
+			buckets := []Bucket{}
+			for _, b := range listed {
+				bucket := newBucket(name, b)
+				bucket.Region = regions[bucket.Name]
+				bucket.Err = regionErrs[bucket.Name]
+				buckets = append(buckets, bucket)
+			}
+			targets[i] = target{clients, buckets}
+		}(i, name)
+	}
+	wg.Wait()
+	return targets
+}
+
+// dedupTargets ... keep each bucket listed by several profiles only in the first profile's target
+// so totals do not count it twice, noting the profiles which saw it on stderr and in SeenBy
+func dedupTargets(targets []target) []target {
+	first := map[string]*Bucket{}
+	for i := range targets {
+		kept := []Bucket{}
+		for _, bucket := range targets[i].buckets {
+			key := bucket.Name + "/" + bucket.Region
+			if seen, ok := first[key]; ok {
+				seen.SeenBy = append(seen.SeenBy, bucket.Profile)
+				continue
+			}
+			kept = append(kept, bucket)
+		}
+		targets[i].buckets = kept
+		for j := range kept {
+			first[kept[j].Name+"/"+kept[j].Region] = &kept[j]
+		}
+	}
+	for _, t := range targets {
+		for i := range t.buckets {
+			bucket := &t.buckets[i]
+			if len(bucket.SeenBy) > 0 {
+				bucket.SeenBy = append([]string{bucket.Profile}, bucket.SeenBy...)
+				fmt.Fprintf(os.Stderr, "bucket %s is listed by profiles %s, scanning it only with %s\n",
+					bucket.Name, strings.Join(bucket.SeenBy, ","), bucket.Profile)
+			}
+		}
+	}
+	return targets
+}
+
+// newBucket ... Bucket of profile built from ListBuckets metadata
+func newBucket(profile string, b *s3.Bucket) Bucket {
+	return Bucket{
+		Name:         aws.StringValue(b.Name),
+		Alias:        aliases[aws.StringValue(b.Name)],
+		Profile:      profile,
+		CreationDate: b.CreationDate,
+	}
+}
+
+// scanTargets ... scan all targets concurrently, fn is called serially across them
+func scanTargets(ctx context.Context, targets []target, fn func(Bucket)) {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+
+	shared := make(chan int, maxConcurrency)
+	var sharedAIMD *aimd
+	controllers := []*aimd{}
+	for _, t := range targets {
+		limiter := shared
+		if limiterPerProfile {
+			limiter = make(chan int, maxConcurrency)
+		}
+		if autoConcurrency {
+			if limiterPerProfile || sharedAIMD == nil {
+				sharedAIMD = newAIMD(limiter)
+				controllers = append(controllers, sharedAIMD)
+			}
+			t.clients.concurrency = sharedAIMD
+		}
+		wg.Add(1)
+		go func(t target, limiter chan int) {
+			defer wg.Done()
+			scan(ctx, t.clients, t.buckets, limiter, &mu, fn)
+		}(t, limiter)
+	}
+	wg.Wait()
+	for _, controller := range controllers {
+		controller.stop()
+		controller.printSummary()
+	}
+}
+
+// comparedRegions ... regions of -compare-regions
+func comparedRegions() []string {
+	return splitList(compareRegions)
+}
+
+// splitList ... non-empty items of a comma separated flag value
+func splitList(value string) []string {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
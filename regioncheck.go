@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// regionCheck ... bucket without any datapoint in the region its metrics were queried in,
+// compared with the region GetBucketLocation reports
+type regionCheck struct {
+	Name     string `json:"name"`
+	Profile  string `json:"profile"`
+	Queried  string `json:"queried"`
+	Location string `json:"location"`
+	Mismatch bool   `json:"mismatch"`
+}
+
+// checkRegions ... regionCheck of each bucket without any datapoint, the location is looked up
+// with the listing client of the bucket's profile, unknown if that fails
+func checkRegions(targets []target, buckets []Bucket) []regionCheck {
+	clients := map[string]*awsClients{}
+	for _, t := range targets {
+		clients[t.clients.profile] = t.clients
+	}
+	checks := []regionCheck{}
+	for _, bucket := range buckets {
+		if !bucket.NoData || bucket.Err != nil {
+			continue
+		}
+		c := clients[bucket.Profile]
+		location, err := getLocation(c.S3(c.region), bucket.Name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to get bucket %s's location: %s\n", bucket.Name, errorSummary(err))
+			location = "unknown"
+		}
+		checks = append(checks, regionCheck{
+			Name:     bucket.Name,
+			Profile:  bucket.Profile,
+			Queried:  bucket.Region,
+			Location: location,
+			Mismatch: err == nil && location != bucket.Region,
+		})
+	}
+	return checks
+}
+
+// printRegionChecks ... buckets without datapoints with the queried and the reported region
+func printRegionChecks(checks []regionCheck) {
+	switch output {
+	case "table":
+		fmt.Printf("%-16s %-16s %-8s  BucketName\n", "Queried", "Location", "Mismatch")
+		for _, check := range checks {
+			fmt.Printf("%-16s %-16s %-8s  %s\n", emptyRegion(check.Queried), check.Location, mismatchLabel(check.Mismatch), check.Name)
+		}
+	case "json":
+		exitOnError(printJSONValue(os.Stdout, struct {
+			Buckets []regionCheck `json:"buckets"`
+		}{checks}))
+	case "markdown":
+		fmt.Println("| BucketName | Queried | Location | Mismatch |")
+		fmt.Println("|---|---|---|---|")
+		for _, check := range checks {
+			fmt.Printf("| %s | %s | %s | %s |\n", check.Name, emptyRegion(check.Queried), check.Location, mismatchLabel(check.Mismatch))
+		}
+	}
+}
+
+// emptyRegion ... region, or a dash if it was never resolved
+func emptyRegion(region string) string {
+	if region == "" {
+		return "-"
+	}
+	return region
+}
+
+// mismatchLabel ... yes or no of a region check
+func mismatchLabel(mismatch bool) string {
+	if mismatch {
+		return "yes"
+	}
+	return "no"
+}
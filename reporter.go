@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// Reporter ... writes scanned buckets and their totals in an output format
+// WriteBucket is called once per bucket in report order, then WriteTotals once
+type Reporter interface {
+	WriteBucket(Bucket) error
+	WriteTotals(Totals) error
+}
+
+// newReporter ... reporter of -template or the -o format writing to w
+func newReporter(w io.Writer) Reporter {
+	sw := &stickyWriter{w: w}
+	switch {
+	case bucketTemplate != nil:
+		return &templateReporter{w: sw}
+	case len(selected) > 0:
+		return &fieldsReporter{w: sw, fields: selected, rows: []fieldRow{}}
+	case output == "markdown":
+		return &markdownReporter{w: sw}
+	case output == "json":
+		return &jsonReporter{w: sw, buckets: []Bucket{}}
+	case output == "grafana":
+		return &grafanaReporter{w: sw, rows: [][]interface{}{}}
+	}
+	return &tableReporter{w: sw}
+}
+
+// stickyWriter ... keeps the first write error so formatted writes need not be checked one by one
+type stickyWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (s *stickyWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n, err := s.w.Write(p)
+	s.err = err
+	return n, err
+}
+
+// exitOnError ... print err and exit if it is not nil, dropping an unfinished -out file
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		exit(1)
+	}
+}
+
+// tableReporter ... aligned columns, the header is written before the first bucket
+type tableReporter struct {
+	w      *stickyWriter
+	header bool
+}
+
+func (r *tableReporter) writeHeader() {
+	if r.header {
+		return
+	}
+	r.header = true
+	fmt.Fprintf(r.w, "%12s %14s %14s", "ObjectCount", sizeLabel(), "Charges-USD")
+	for _, col := range extraColumns() {
+		fmt.Fprintf(r.w, " %*s", col.width, col.header)
+	}
+	fmt.Fprintln(r.w, "  BucketName (Region)")
+}
+
+func (r *tableReporter) WriteBucket(bucket Bucket) error {
+	r.writeHeader()
+	fmt.Fprintf(r.w, "%12d %14s %14s",
+		int(bucket.NumberOfObjects),
+		emptyOr(bucket, formatSize(bucket.TotalSize)),
+		emptyOr(bucket, formatCost(bucket.TotalCost)))
+	for _, col := range extraColumns() {
+		fmt.Fprintf(r.w, " %*s", col.width, col.value(bucket))
+	}
+	fmt.Fprintf(r.w, "  %s (%s)%s\n", displayName(bucket), bucket.Region, timeoutNote(bucket))
+	if verbose {
+		if compact {
+			r.writeCompact(usageLines(bucket))
+		} else {
+			r.writeLines(usageLines(bucket))
+		}
+		if archiveSize(bucket) >= archiveAdvisoryGB*binaryGB/sizeDivisor() {
+			fmt.Fprintln(r.w, "   * Glacier/Deep Archive retrieval and early deletion charges are not included")
+		}
+		if !compact {
+			fmt.Fprintln(r.w)
+		}
+	}
+	return r.w.err
+}
+
+// writeLines ... -v breakdown with one line per storage type or group
+func (r *tableReporter) writeLines(lines []usageLine) {
+	for _, line := range lines {
+		size := ""
+		if line.sized {
+			size = formatSize(line.size)
+		}
+		fmt.Fprintf(r.w, " %26s %14s   - %s%s\n", size, formatCost(line.cost), line.label, line.note)
+	}
+}
+
+// compactWidth ... column at which a -compact breakdown wraps
+const compactWidth = 100
+
+// writeCompact ... -v breakdown as label:size/$cost items on one indented line wrapped at compactWidth
+func (r *tableReporter) writeCompact(lines []usageLine) {
+	if len(lines) == 0 {
+		return
+	}
+	unit := "GB"
+	if rawBytes {
+		unit = "B"
+	}
+	width := 0
+	for _, line := range lines {
+		item := line.label + ":$" + formatCost(line.cost)
+		if line.sized {
+			item = line.label + ":" + formatSize(line.size) + unit + "/$" + formatCost(line.cost)
+		}
+		if width > 0 && width+1+len(item) > compactWidth {
+			fmt.Fprintln(r.w)
+			width = 0
+		}
+		if width == 0 {
+			fmt.Fprint(r.w, "   ")
+			width = 3
+		}
+		fmt.Fprint(r.w, " "+item)
+		width += 1 + len(item)
+	}
+	fmt.Fprintln(r.w)
+}
+
+// WriteTotals ... the table has no totals row, only the header is ensured for an empty report
+func (r *tableReporter) WriteTotals(totals Totals) error {
+	r.writeHeader()
+	return r.w.err
+}
+
+// usageLine ... one line of the -v breakdown, the monitoring fee has no size
+type usageLine struct {
+	label string
+	sized bool
+	size  float64
+	cost  float64
+	note  string
+}
+
+// usageLines ... -v breakdown of bucket, storage types with size then groups and the monitoring fee
+func usageLines(bucket Bucket) []usageLine {
+	lines := []usageLine{}
+	for _, storageType := range bucket.pricedTypes() {
+		if _, grouped := groupOf[storageType]; grouped && !rawTypes {
+			continue
+		}
+		if bucket.Sizes[storageType] != 0.0 {
+			price, ok := bucket.PricesUsed[storageType]
+			lines = append(lines, usageLine{storageTypeLabel(storageType), true, bucket.Sizes[storageType], bucket.Costs[storageType], priceNote(price, ok)})
+		}
+	}
+	if !rawTypes {
+		for _, group := range storageGroups {
+			size, cost := groupUsage(bucket, group)
+			if size != 0.0 {
+				price, ok := groupPrice(bucket, group)
+				lines = append(lines, usageLine{group.label, true, size, cost, priceNote(price, ok)})
+			}
+		}
+	}
+	if cost := bucket.Costs[itMonitoringCost]; cost != 0 {
+		lines = append(lines, usageLine{label: "Intelligent-Tiering monitoring (estimated)", cost: cost})
+	}
+	return lines
+}
+
+// priceNote ... unit price applied to a -v line, empty if none was
+func priceNote(price float64, ok bool) string {
+	if !ok {
+		return ""
+	}
+	return " @ " + strconv.FormatFloat(price, 'f', -1, 64) + " USD/GB-month"
+}
+
+// timeoutNote ... marker after the name of a bucket -timeout-per-bucket gave up on, its figures are partial
+func timeoutNote(bucket Bucket) string {
+	if aerr, ok := bucket.Err.(awserr.Error); ok && aerr.Code() == timeoutCode {
+		return " [timed out]"
+	}
+	return ""
+}
+
+// emptyOr ... N/A for a bucket without any size datapoint under -empty na, otherwise value
+func emptyOr(bucket Bucket, value string) string {
+	if bucket.NoData && empty == "na" {
+		return "N/A"
+	}
+	return value
+}
+
+// reportLabel ... -title and the run's start time embedded in json reports, empty without -title
+type reportLabel struct {
+	Title       string     `json:"title,omitempty"`
+	GeneratedAt *time.Time `json:"generatedAt,omitempty"`
+}
+
+func newReportLabel() reportLabel {
+	if reportTitle == "" {
+		return reportLabel{}
+	}
+	generatedAt := startedAt
+	return reportLabel{reportTitle, &generatedAt}
+}
+
+// writeMarkdownTitle ... -title heading and the run's start time above a markdown table, nothing without -title
+func writeMarkdownTitle(w io.Writer) {
+	if reportTitle == "" {
+		return
+	}
+	fmt.Fprintf(w, "# %s\n\nGenerated at %s\n\n", reportTitle, startedAt.Format(time.RFC3339))
+}
+
+// markdownReporter ... github flavored markdown table with a totals row
+type markdownReporter struct {
+	w      *stickyWriter
+	header bool
+}
+
+func (r *markdownReporter) writeHeader() {
+	if r.header {
+		return
+	}
+	r.header = true
+	writeMarkdownTitle(r.w)
+	columns := extraColumns()
+	fmt.Fprintf(r.w, "| BucketName | Region | ObjectCount | %s | Charges-USD |", sizeLabel())
+	for _, col := range columns {
+		fmt.Fprintf(r.w, " %s |", col.header)
+	}
+	fmt.Fprintln(r.w)
+	fmt.Fprint(r.w, "|---|---|---:|---:|---:|")
+	for range columns {
+		fmt.Fprint(r.w, "---|")
+	}
+	fmt.Fprintln(r.w)
+}
+
+func (r *markdownReporter) WriteBucket(bucket Bucket) error {
+	r.writeHeader()
+	fmt.Fprintf(r.w, "| %s%s | %s | %d | %s | %s |",
+		displayName(bucket), timeoutNote(bucket),
+		bucket.Region,
+		int(bucket.NumberOfObjects),
+		emptyOr(bucket, formatSize(bucket.TotalSize)),
+		emptyOr(bucket, formatCost(bucket.TotalCost)))
+	for _, col := range extraColumns() {
+		fmt.Fprintf(r.w, " %s |", col.value(bucket))
+	}
+	fmt.Fprintln(r.w)
+	return r.w.err
+}
+
+func (r *markdownReporter) WriteTotals(totals Totals) error {
+	r.writeHeader()
+	fmt.Fprintf(r.w, "| **Total (%d buckets)** | | **%d** | **%s** | **%s** |",
+		totals.NumberOfBuckets,
+		int(totals.NumberOfObjects),
+		formatSize(totals.TotalSize),
+		formatCost(totals.TotalCost))
+	for range extraColumns() {
+		fmt.Fprint(r.w, " |")
+	}
+	fmt.Fprintln(r.w)
+	return r.w.err
+}
+
+// jsonReporter ... buckets and their totals as one json document, values are not rounded
+type jsonReporter struct {
+	w       *stickyWriter
+	buckets []Bucket
+}
+
+func (r *jsonReporter) WriteBucket(bucket Bucket) error {
+	r.buckets = append(r.buckets, bucket)
+	return nil
+}
+
+func (r *jsonReporter) WriteTotals(totals Totals) error {
+	if regionTotals {
+		return printJSONValue(r.w, struct {
+			reportLabel
+			Buckets []Bucket       `json:"buckets"`
+			Regions []RegionTotals `json:"regions"`
+			Totals  Totals         `json:"totals"`
+		}{newReportLabel(), r.buckets, totals.Regions(), totals})
+	}
+	return printJSONValue(r.w, struct {
+		reportLabel
+		Buckets []Bucket `json:"buckets"`
+		Totals  Totals   `json:"totals"`
+	}{newReportLabel(), r.buckets, totals})
+}
+
+// grafanaReporter ... a table of buckets and a time series per total as a SimpleJSON/Infinity
+// datasource response, the totals are stamped with the end of the metric window
+type grafanaReporter struct {
+	w    *stickyWriter
+	rows [][]interface{}
+}
+
+// grafanaColumn ... column of a grafana table response
+type grafanaColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+func (r *grafanaReporter) WriteBucket(bucket Bucket) error {
+	r.rows = append(r.rows, []interface{}{displayName(bucket), bucket.Profile, bucket.Region, bucket.NumberOfObjects, bucket.TotalSize, bucket.TotalCost})
+	return nil
+}
+
+func (r *grafanaReporter) WriteTotals(totals Totals) error {
+	type table struct {
+		Type    string          `json:"type"`
+		Columns []grafanaColumn `json:"columns"`
+		Rows    [][]interface{} `json:"rows"`
+	}
+	type series struct {
+		Target     string          `json:"target"`
+		Datapoints [][]interface{} `json:"datapoints"`
+	}
+	at := windowEnd().UnixNano() / int64(time.Millisecond)
+	return printJSONValue(r.w, []interface{}{
+		table{"table", []grafanaColumn{
+			{"BucketName", "string"},
+			{"Profile", "string"},
+			{"Region", "string"},
+			{"ObjectCount", "number"},
+			{sizeLabel(), "number"},
+			{"Charges-USD", "number"},
+		}, r.rows},
+		series{"numberOfObjects", [][]interface{}{{totals.NumberOfObjects, at}}},
+		series{"totalSize", [][]interface{}{{totals.TotalSize, at}}},
+		series{"totalCost", [][]interface{}{{totals.TotalCost, at}}},
+	})
+}
+
+// fieldsReporter ... only the -fields columns in their given order, json keys follow the field names
+type fieldsReporter struct {
+	w      *stickyWriter
+	fields []field
+	header bool
+	rows   []fieldRow
+}
+
+// fieldRow ... json object of a bucket's fields keeping the -fields order
+type fieldRow struct {
+	fields []field
+	bucket Bucket
+}
+
+func (row fieldRow) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, f := range row.fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		value, err := json.Marshal(f.value(row.bucket))
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, "%q:%s", f.name, value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (r *fieldsReporter) writeHeader() {
+	if r.header || output == "json" {
+		return
+	}
+	r.header = true
+	if output == "markdown" {
+		writeMarkdownTitle(r.w)
+	}
+	for i, f := range r.fields {
+		r.writeCell(i, f, f.header)
+	}
+	if output == "markdown" {
+		fmt.Fprintln(r.w, "|")
+		fmt.Fprint(r.w, "|")
+		for range r.fields {
+			fmt.Fprint(r.w, "---|")
+		}
+	}
+	fmt.Fprintln(r.w)
+}
+
+func (r *fieldsReporter) WriteBucket(bucket Bucket) error {
+	if output == "json" {
+		r.rows = append(r.rows, fieldRow{r.fields, bucket})
+		return nil
+	}
+	r.writeHeader()
+	for i, f := range r.fields {
+		r.writeCell(i, f, f.text(bucket))
+	}
+	if output == "markdown" {
+		fmt.Fprint(r.w, "|")
+	}
+	fmt.Fprintln(r.w)
+	return r.w.err
+}
+
+// writeCell ... i-th cell of a row, names are left aligned and other values right aligned in the table
+func (r *fieldsReporter) writeCell(i int, f field, text string) {
+	switch {
+	case output == "markdown":
+		fmt.Fprintf(r.w, "| %s ", text)
+		return
+	case i > 0:
+		fmt.Fprint(r.w, " ")
+	}
+	if f.name == "name" || f.name == "alias" {
+		fmt.Fprintf(r.w, "%-*s", f.width, text)
+		return
+	}
+	fmt.Fprintf(r.w, "%*s", f.width, text)
+}
+
+// WriteTotals ... json carries the totals next to the rows, text formats only list buckets
+func (r *fieldsReporter) WriteTotals(totals Totals) error {
+	if output != "json" {
+		r.writeHeader()
+		return r.w.err
+	}
+	return printJSONValue(r.w, struct {
+		reportLabel
+		Buckets []fieldRow `json:"buckets"`
+		Totals  Totals     `json:"totals"`
+	}{newReportLabel(), r.rows, totals})
+}
+
+// templateReporter ... one -template line per bucket and the -template-footer line for totals
+type templateReporter struct {
+	w *stickyWriter
+}
+
+func (r *templateReporter) WriteBucket(bucket Bucket) error {
+	return r.execute(bucketTemplate, bucket)
+}
+
+func (r *templateReporter) WriteTotals(totals Totals) error {
+	if footerTemplate == nil {
+		return nil
+	}
+	return r.execute(footerTemplate, totals)
+}
+
+func (r *templateReporter) execute(tmpl *template.Template, data interface{}) error {
+	if err := tmpl.Execute(r.w, data); err != nil {
+		return fmt.Errorf("unable to execute %s: %v", tmpl.Name(), err)
+	}
+	fmt.Fprintln(r.w)
+	return r.w.err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// retriesTaken ... retries of all clients so far, bounded by -retry-budget
+var retriesTaken int64
+
+// budgetWarned ... set once the exhausted -retry-budget was warned about
+var budgetWarned int32
+
+// budgetRetryer ... default aws retryer which stops retrying every client once -retry-budget retries were taken,
+// requests failing afterwards return their error
+type budgetRetryer struct {
+	client.DefaultRetryer
+}
+
+func (r budgetRetryer) ShouldRetry(req *request.Request) bool {
+	if !r.DefaultRetryer.ShouldRetry(req) {
+		return false
+	}
+	if atomic.AddInt64(&retriesTaken, 1) > int64(retryBudget) {
+		if atomic.CompareAndSwapInt32(&budgetWarned, 0, 1) {
+			fmt.Fprintf(os.Stderr, "retry budget of %d exhausted, failing requests are no longer retried\n", retryBudget)
+		}
+		return false
+	}
+	return true
+}
+
+// resetRetryBudget ... give a new scan the whole -retry-budget again
+func resetRetryBudget() {
+	atomic.StoreInt64(&retriesTaken, 0)
+	atomic.StoreInt32(&budgetWarned, 0)
+}
+
+// regionConfig ... config of a client in region, retrying within -retry-budget if set
+func regionConfig(region string) *aws.Config {
+	config := aws.NewConfig().WithRegion(region)
+	if retryBudget <= 0 {
+		return config
+	}
+	return request.WithRetryer(config, budgetRetryer{client.DefaultRetryer{NumMaxRetries: client.DefaultRetryerMaxNumRetries}})
+}
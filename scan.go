@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	maxConcurrency    = 20
+	objectsWindowDays = 2
+	sizeWindowDays    = 3
+	maxTagConcurrency = 5
+)
+
+// tagLimiter ... bounds concurrent GetBucketTagging calls across all scans
+var tagLimiter = make(chan int, maxTagConcurrency)
+
+// Scan ... fetch usage of each bucket, fn is called serially as each bucket completes
+// once ctx is canceled no more buckets are started and unfinished ones are not passed to fn
+func Scan(ctx context.Context, cp clientProvider, buckets []Bucket, fn func(Bucket)) {
+	scan(ctx, cp, buckets, make(chan int, maxConcurrency), &sync.Mutex{}, fn)
+}
+
+// scan ... Scan sharing limiter and the lock serializing fn with other scans
+func scan(ctx context.Context, cp clientProvider, buckets []Bucket, limiter chan int, mu *sync.Mutex, fn func(Bucket)) {
+	var wg sync.WaitGroup
+
+	for _, bucket := range buckets {
+		select {
+		case limiter <- 1:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(bucket Bucket) {
+			defer func() {
+				<-limiter
+				wg.Done()
+			}()
+			if !sleepJitter(ctx) {
+				return
+			}
+			if isBucketGone(bucket.Err) {
+				fmt.Fprintf(os.Stderr, "skipping bucket %s: deleted since listing\n", bucket.Name)
+				return
+			}
+			if bucket.Err != nil && skipInaccessible {
+				fmt.Fprintf(os.Stderr, "skipping bucket %s: %s\n", bucket.Name, errorSummary(bucket.Err))
+				return
+			}
+			if ownerID != "" || verbose || ownerField {
+				bucket.Owner = getOwner(cp.S3(bucket.Region), bucket.Name)
+				if ownerID != "" && bucket.Owner != ownerID {
+					return
+				}
+			}
+			if err := scanBucketWithTimeout(ctx, cp, &bucket); err != nil && bucket.Err == nil {
+				bucket.Err = err
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if activeOnly && activeLabel(bucket) != "yes" {
+				return
+			}
+			if isAccessDenied(bucket.Err) && skipInaccessible {
+				fmt.Fprintf(os.Stderr, "skipping bucket %s: %s\n", bucket.Name, errorSummary(bucket.Err))
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			fn(bucket)
+		}(bucket)
+	}
+	wg.Wait()
+}
+
+// sleepJitter ... wait a random duration up to -jitter, false if ctx is canceled meanwhile
+func sleepJitter(ctx context.Context) bool {
+	if jitter <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(time.Duration(rand.Int63n(int64(jitter)))):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// timeoutCode ... error code of a bucket which ran out of -timeout-per-bucket
+const timeoutCode = "Timeout"
+
+// scanBucketWithTimeout ... scanBucket bounded by -timeout-per-bucket so a slow bucket frees its limiter slot,
+// a bucket running out of time reports a Timeout error
+func scanBucketWithTimeout(ctx context.Context, cp clientProvider, bucket *Bucket) error {
+	if timeoutPerBucket <= 0 {
+		return scanBucket(ctx, cp, bucket)
+	}
+	bucketCtx, cancel := context.WithTimeout(ctx, timeoutPerBucket)
+	defer cancel()
+	err := scanBucket(bucketCtx, cp, bucket)
+	if bucketCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return awserr.New(timeoutCode, fmt.Sprintf("scan did not finish within %s", timeoutPerBucket), err)
+	}
+	return err
+}
+
+// scanBucket ... fill object count, sizes and costs of bucket
+// returns the first cloudwatch error, stops early when access is denied
+func scanBucket(ctx context.Context, cp clientProvider, bucket *Bucket) error {
+	cwSvc := cp.CloudWatch(bucket.Region)
+	fetch := fetchMetricData
+	if legacyMetrics {
+		fetch = fetchMetrics
+	}
+	if discoverTypes {
+		types, err := discovered.storageTypes(ctx, cwSvc, *bucket)
+		if isAccessDenied(err) {
+			return err
+		}
+		if err == nil {
+			bucket.types = types
+		}
+	}
+	measure := func(days int) error {
+		// datapoints returned by each metric of this attempt, the last attempt's are kept
+		bucket.Datapoints = map[string]int{}
+		if useMetricMath() {
+			count, sizes, costs, err := fetchMetricMath(ctx, cwSvc, *bucket, days, bucket.Datapoints)
+			bucket.NumberOfObjects = count
+			applyTotals(bucket, sizes, costs)
+			return err
+		}
+		count, sizeBytes, prevBytes, err := fetch(ctx, cwSvc, *bucket, days, bucket.Datapoints)
+		bucket.NumberOfObjects = count
+		applySizes(bucket, sizeBytes, prevBytes)
+		return err
+	}
+	firstErr := measure(sizeWindowDays)
+	if isAccessDenied(firstErr) {
+		return firstErr
+	}
+	// zero size with objects is a metric gap, retry with a wider window
+	for retry := 1; retry <= retryOnEmpty && bucket.TotalSize == 0 && bucket.NumberOfObjects > 0; retry++ {
+		if err := measure(sizeWindowDays * (retry + 1)); isAccessDenied(err) {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if activeDays > 0 {
+		change, err := getObjectCountChange(ctx, cwSvc, *bucket, activeDays)
+		if isAccessDenied(err) {
+			return err
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		bucket.ObjectsChange = change
+	}
+	if lifecycle {
+		bucket.LifecycleRules = getLifecycleRules(cp.S3(bucket.Region), bucket.Name)
+	}
+	if versioning {
+		bucket.Versioning = getVersioning(cp.S3(bucket.Region), bucket.Name)
+	}
+	if requesterPays {
+		bucket.Payer = getPayer(cp.S3(bucket.Region), bucket.Name)
+	}
+	if replication {
+		bucket.Replication = getReplication(cp.S3(bucket.Region), cp, *bucket)
+	}
+	// tags are only reported in json and by .Tags of -template, other outputs skip the GetBucketTagging calls
+	if tagsAll && (jsonOutput() || templateText != "") {
+		tagLimiter <- 1
+		bucket.Tags = getTags(cp.S3(bucket.Region), bucket.Name)
+		<-tagLimiter
+	}
+	return firstErr
+}
+
+// fetchMetrics ... object count, and newest and previous size in bytes of each storage type
+// with a datapoint looking back days, one GetMetricStatistics call per metric,
+// the object count is fetched alongside the sizes,
+// datapoints receives the number of datapoints of each metric,
+// returns the first cloudwatch error, stops early when access is denied
+func fetchMetrics(ctx context.Context, cwSvc cloudwatchAPI, bucket Bucket, days int, datapoints map[string]int) (float64, map[string]float64, map[string]float64, error) {
+	var count float64
+	var n int
+	var countErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		count, n, countErr = getNumberOfObjects(ctx, cwSvc, bucket)
+	}()
+
+	sizeBytes := map[string]float64{}
+	prevBytes := map[string]float64{}
+	var sizeErr error
+	if parallelSizes {
+		sizeErr = fetchSizesParallel(ctx, cwSvc, bucket, days, datapoints, sizeBytes, prevBytes)
+	} else {
+		for _, storageType := range bucket.sizeTypes() {
+			series, err := getBucketSizeBytes(ctx, cwSvc, bucket, storageType, days)
+			datapoints[storageType] = len(series)
+			if sizeErr == nil {
+				sizeErr = err
+			}
+			if isAccessDenied(err) {
+				break
+			}
+			splitSeries(storageType, series, sizeBytes, prevBytes)
+		}
+	}
+	<-done
+	datapoints["NumberOfObjects"] = n
+	if countErr != nil {
+		return count, sizeBytes, prevBytes, countErr
+	}
+	return count, sizeBytes, prevBytes, sizeErr
+}
+
+// fetchSizesParallel ... BucketSizeBytes of all storage types of bucket at once on the shared client,
+// returns the error of the first failed type in type order
+func fetchSizesParallel(ctx context.Context, cwSvc cloudwatchAPI, bucket Bucket, days int, datapoints map[string]int, sizeBytes, prevBytes map[string]float64) error {
+	var wg sync.WaitGroup
+
+	types := bucket.sizeTypes()
+	series := make([][]float64, len(types))
+	errs := make([]error, len(types))
+	for i, storageType := range types {
+		wg.Add(1)
+		go func(i int, storageType string) {
+			defer wg.Done()
+			series[i], errs[i] = getBucketSizeBytes(ctx, cwSvc, bucket, storageType, days)
+		}(i, storageType)
+	}
+	wg.Wait()
+
+	var sizeErr error
+	for i, storageType := range types {
+		datapoints[storageType] = len(series[i])
+		if sizeErr == nil {
+			sizeErr = errs[i]
+		}
+		splitSeries(storageType, series[i], sizeBytes, prevBytes)
+	}
+	return sizeErr
+}
+
+// splitSeries ... store the newest value of a newest first series in sizeBytes and the one before in prevBytes
+func splitSeries(storageType string, series []float64, sizeBytes, prevBytes map[string]float64) {
+	if len(series) > 0 {
+		sizeBytes[storageType] = series[0]
+	}
+	if len(series) > 1 {
+		prevBytes[storageType] = series[1]
+	}
+}
+
+// applySizes ... set sizes, costs and growth of bucket from newest and previous bytes of each storage type,
+// storage types missing from sizeBytes had no datapoint, the object count must be set beforehand
+func applySizes(bucket *Bucket, sizeBytes, prevBytes map[string]float64) {
+	bucket.NoData = len(sizeBytes) == 0
+	bucket.Growth = sizeGrowth(sizeBytes, prevBytes)
+	bucket.Sizes = map[string]float64{}
+	bucket.TotalSize = 0
+	// GB-months charged of each storage type
+	gbMonths := map[string]float64{}
+	for _, storageType := range bucket.pricedTypes() {
+		tmpBytes := sizeBytes[storageType]
+		bucket.Sizes[storageType] = tmpBytes / sizeDivisor()
+		bucket.TotalSize += tmpBytes / sizeDivisor()
+		gbMonths[storageType] = bytesToGB(tmpBytes, binaryGB) * monthFactor()
+	}
+	bucket.PricesUsed = bucket.priceTable(bucket.Region)
+	bucket.TotalCost, bucket.Costs = ComputeCost(gbMonths, bucket.PricesUsed)
+	fee := itMonitoringFee(bucket.NumberOfObjects, sizeBytes)
+	if fee != 0 {
+		bucket.Costs[itMonitoringCost] = fee
+		bucket.TotalCost += fee
+	}
+	bucket.RegionCosts = nil
+	if len(comparedRegions()) > 0 {
+		bucket.RegionCosts = map[string]float64{}
+	}
+	for _, region := range comparedRegions() {
+		cost, _ := ComputeCost(gbMonths, bucket.priceTable(region))
+		bucket.RegionCosts[region] = cost + fee
+	}
+}
+
+// applyTotals ... set total size, cost and growth of bucket from newest first series of
+// total bytes and cost per month, sizes and costs per storage type are left empty
+func applyTotals(bucket *Bucket, sizes, costs []float64) {
+	bucket.NoData = len(sizes) == 0
+	bucket.PricesUsed = priceTable(bucket.Region)
+	bucket.Sizes = map[string]float64{}
+	bucket.Costs = map[string]float64{}
+	bucket.RegionCosts = nil
+	bucket.TotalSize = 0
+	bucket.TotalCost = 0
+	bucket.Growth = nil
+	if len(sizes) > 0 {
+		bucket.TotalSize = sizes[0] / sizeDivisor()
+	}
+	if len(costs) > 0 {
+		bucket.TotalCost = costs[0] * monthFactor()
+	}
+	if len(sizes) > 1 && sizes[1] != 0 {
+		growth := (sizes[0] - sizes[1]) / sizes[1] * 100
+		bucket.Growth = &growth
+	}
+}
+
+// sizeGrowth ... percent the total size grew since the previous datapoint, nil without one
+func sizeGrowth(sizeBytes, prevBytes map[string]float64) *float64 {
+	if len(prevBytes) == 0 {
+		return nil
+	}
+	total, prevTotal := 0.0, 0.0
+	for _, tmpBytes := range sizeBytes {
+		total += tmpBytes
+	}
+	for _, tmpBytes := range prevBytes {
+		prevTotal += tmpBytes
+	}
+	if prevTotal == 0 {
+		return nil
+	}
+	growth := (total - prevTotal) / prevTotal * 100
+	return &growth
+}
+
+// monthFactor ... share of the month charged, 1 projects the current size over the whole month
+func monthFactor() float64 {
+	if daysInMonth <= 0 {
+		return 1
+	}
+	return float64(daysInMonth) / float64(currentMonthDays())
+}
+
+// currentMonthDays ... number of days in the current month
+func currentMonthDays() int {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month()+1, 0, 0, 0, 0, 0, now.Location()).Day()
+}
+
+// isAccessDenied ... whether err is an aws permission error
+func isAccessDenied(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case "AccessDenied", "AccessDeniedException", "Forbidden":
+			return true
+		}
+	}
+	return false
+}
+
+// isBucketGone ... whether err means the bucket no longer exists
+func isBucketGone(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchBucket, "NotFound":
+			return true
+		}
+	}
+	return false
+}
+
+// errorSummary ... single line description of err
+func errorSummary(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code() + ": " + aerr.Message()
+	}
+	return err.Error()
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+// fakeCloudWatch ... cloudwatchAPI serving canned daily values of each bucket, metric and storage type,
+// newest value first, each call waits latency to stand in for a round trip
+type fakeCloudWatch struct {
+	series  map[string][]float64
+	latency time.Duration
+	calls   int64
+}
+
+// fakeKey ... key of a series in fakeCloudWatch
+func fakeKey(bucketName, metricName, storageType string) string {
+	return bucketName + "/" + metricName + "/" + storageType
+}
+
+// dimensionKey ... fakeKey of a metric by its dimensions
+func dimensionKey(metricName string, dimensions []*cloudwatch.Dimension) string {
+	var bucketName, storageType string
+	for _, dimension := range dimensions {
+		switch aws.StringValue(dimension.Name) {
+		case "BucketName":
+			bucketName = aws.StringValue(dimension.Value)
+		case "StorageType":
+			storageType = aws.StringValue(dimension.Value)
+		}
+	}
+	return fakeKey(bucketName, metricName, storageType)
+}
+
+func (f *fakeCloudWatch) wait(ctx aws.Context) error {
+	atomic.AddInt64(&f.calls, 1)
+	if f.latency <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(f.latency):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// GetMetricStatisticsWithContext ... datapoints oldest first, callers must sort them
+func (f *fakeCloudWatch) GetMetricStatisticsWithContext(ctx aws.Context, input *cloudwatch.GetMetricStatisticsInput, opts ...request.Option) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	if err := f.wait(ctx); err != nil {
+		return nil, err
+	}
+	values := f.series[dimensionKey(aws.StringValue(input.MetricName), input.Dimensions)]
+	resp := &cloudwatch.GetMetricStatisticsOutput{}
+	for i := len(values) - 1; i >= 0; i-- {
+		resp.Datapoints = append(resp.Datapoints, &cloudwatch.Datapoint{
+			Timestamp: aws.Time(windowEnd().Add(time.Duration(-24*(i+1)) * time.Hour)),
+			Average:   aws.Float64(values[i]),
+		})
+	}
+	return resp, nil
+}
+
+// GetMetricDataPagesWithContext ... values of each query newest first, the newest on a first page
+// and the rest on a second one, expressions are not evaluated
+func (f *fakeCloudWatch) GetMetricDataPagesWithContext(ctx aws.Context, input *cloudwatch.GetMetricDataInput, fn func(*cloudwatch.GetMetricDataOutput, bool) bool, opts ...request.Option) error {
+	if err := f.wait(ctx); err != nil {
+		return err
+	}
+	first, rest := &cloudwatch.GetMetricDataOutput{}, &cloudwatch.GetMetricDataOutput{}
+	for _, query := range input.MetricDataQueries {
+		if query.MetricStat == nil {
+			continue
+		}
+		metric := query.MetricStat.Metric
+		values := f.series[dimensionKey(aws.StringValue(metric.MetricName), metric.Dimensions)]
+		if len(values) == 0 {
+			continue
+		}
+		first.MetricDataResults = append(first.MetricDataResults, &cloudwatch.MetricDataResult{
+			Id:     query.Id,
+			Values: aws.Float64Slice(values[:1]),
+		})
+		rest.MetricDataResults = append(rest.MetricDataResults, &cloudwatch.MetricDataResult{
+			Id:     query.Id,
+			Values: aws.Float64Slice(values[1:]),
+		})
+	}
+	if fn(first, false) {
+		fn(rest, true)
+	}
+	return nil
+}
+
+// ListMetricsPagesWithContext ... every BucketSizeBytes series on one page
+func (f *fakeCloudWatch) ListMetricsPagesWithContext(ctx aws.Context, input *cloudwatch.ListMetricsInput, fn func(*cloudwatch.ListMetricsOutput, bool) bool, opts ...request.Option) error {
+	if err := f.wait(ctx); err != nil {
+		return err
+	}
+	page := &cloudwatch.ListMetricsOutput{}
+	for key := range f.series {
+		parts := strings.SplitN(key, "/", 3)
+		bucketName, metricName, storageType := parts[0], parts[1], parts[2]
+		if metricName != aws.StringValue(input.MetricName) {
+			continue
+		}
+		page.Metrics = append(page.Metrics, &cloudwatch.Metric{
+			MetricName: aws.String(metricName),
+			Dimensions: []*cloudwatch.Dimension{
+				{Name: aws.String("BucketName"), Value: aws.String(bucketName)},
+				{Name: aws.String("StorageType"), Value: aws.String(storageType)},
+			},
+		})
+	}
+	fn(page, true)
+	return nil
+}
+
+// fakeClients ... clientProvider of one fakeCloudWatch in every region, buckets live in defaultRegion
+type fakeClients struct {
+	cw *fakeCloudWatch
+}
+
+func (c fakeClients) CloudWatch(region string) cloudwatchAPI {
+	return c.cw
+}
+
+func (c fakeClients) S3(region string) s3API {
+	return nil
+}
+
+func (c fakeClients) BucketRegion(bucketName string) (string, error) {
+	return defaultRegion, nil
+}
+
+// fixture ... n buckets with objects and Standard and Standard-IA sizes of three days,
+// bucket i holds i+1 GiB of Standard and half of that of Standard-IA
+func fixture(n int) (*fakeCloudWatch, []Bucket) {
+	cw := &fakeCloudWatch{series: map[string][]float64{}}
+	buckets := []Bucket{}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("bucket-%03d", i)
+		size := float64(i+1) * binaryGB
+		cw.series[fakeKey(name, "NumberOfObjects", "AllStorageTypes")] = []float64{float64(1000 * (i + 1)), 900}
+		cw.series[fakeKey(name, "BucketSizeBytes", "StandardStorage")] = []float64{size, size / 2, size / 4}
+		cw.series[fakeKey(name, "BucketSizeBytes", "StandardIAStorage")] = []float64{size / 2, size / 2, size / 2}
+		buckets = append(buckets, Bucket{Name: name, Profile: "default", Region: defaultRegion})
+	}
+	return cw, buckets
+}
+
+// scanAll ... buckets scanned by Scan against cw keyed by name
+func scanAll(cw *fakeCloudWatch, buckets []Bucket) map[string]Bucket {
+	results := map[string]Bucket{}
+	Scan(context.Background(), fakeClients{cw}, buckets, func(bucket Bucket) {
+		results[bucket.Name] = bucket
+	})
+	return results
+}
+
+// almostEqual ... whether a and b agree to rounding errors
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Abs(b))
+}
+
+func TestScan(t *testing.T) {
+	cw, buckets := fixture(3)
+	results := scanAll(cw, buckets)
+	if len(results) != len(buckets) {
+		t.Fatalf("scanned %d buckets, want %d", len(results), len(buckets))
+	}
+	for i, bucket := range buckets {
+		got := results[bucket.Name]
+		if got.Err != nil {
+			t.Errorf("%s: unexpected error %v", bucket.Name, got.Err)
+		}
+		if want := float64(1000 * (i + 1)); got.NumberOfObjects != want {
+			t.Errorf("%s: NumberOfObjects = %v, want %v", bucket.Name, got.NumberOfObjects, want)
+		}
+		gb := float64(i + 1)
+		if want := gb * 1.5; !almostEqual(got.TotalSize, want) {
+			t.Errorf("%s: TotalSize = %v, want %v", bucket.Name, got.TotalSize, want)
+		}
+		want := gb*costDef["StandardStorage"] + gb/2*costDef["StandardIAStorage"]
+		if !almostEqual(got.TotalCost, want) {
+			t.Errorf("%s: TotalCost = %v, want %v", bucket.Name, got.TotalCost, want)
+		}
+		if got.Growth == nil || !almostEqual(*got.Growth, 50) {
+			t.Errorf("%s: Growth = %v, want 50", bucket.Name, got.Growth)
+		}
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	cw, buckets := fixture(100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scanAll(cw, buckets)
+	}
+}
+
+func TestScanGlacierInstantRetrieval(t *testing.T) {
+	cw := &fakeCloudWatch{series: map[string][]float64{
+		fakeKey("archive", "NumberOfObjects", "AllStorageTypes"):                {100},
+		fakeKey("archive", "BucketSizeBytes", "GlacierInstantRetrievalStorage"): {200 * binaryGB},
+		fakeKey("archive", "BucketSizeBytes", "GlacierIRSizeOverhead"):          {binaryGB},
+	}}
+	got := scanAll(cw, []Bucket{{Name: "archive", Region: defaultRegion}})["archive"]
+	for storageType, gb := range map[string]float64{"GlacierInstantRetrievalStorage": 200, "GlacierIRSizeOverhead": 1} {
+		if got.Sizes[storageType] != gb {
+			t.Errorf("Sizes[%s] = %v, want %v", storageType, got.Sizes[storageType], gb)
+		}
+		if want := gb * costDef[storageType]; !almostEqual(got.Costs[storageType], want) {
+			t.Errorf("Costs[%s] = %v, want %v", storageType, got.Costs[storageType], want)
+		}
+	}
+	if want := 201 * 0.005; !almostEqual(got.TotalCost, want) {
+		t.Errorf("TotalCost = %v, want %v", got.TotalCost, want)
+	}
+}
+
+func TestScanGlacierObjectOverhead(t *testing.T) {
+	// a million objects of 1KB each, cloudwatch reports the 32KB and 8KB overhead of every object as bytes
+	const objects = 1000000
+	cw := &fakeCloudWatch{series: map[string][]float64{
+		fakeKey("small", "NumberOfObjects", "AllStorageTypes"):         {objects},
+		fakeKey("small", "BucketSizeBytes", "GlacierStorage"):          {objects * 1024},
+		fakeKey("small", "BucketSizeBytes", "GlacierObjectOverhead"):   {objects * 32 * 1024},
+		fakeKey("small", "BucketSizeBytes", "GlacierS3ObjectOverhead"): {objects * 8 * 1024},
+	}}
+	got := scanAll(cw, []Bucket{{Name: "small", Region: defaultRegion}})["small"]
+
+	// pricing the overhead bytes equals pricing each object at its fixed overhead
+	perObject := (32*1024*costDef["GlacierObjectOverhead"] + 8*1024*costDef["GlacierS3ObjectOverhead"]) / binaryGB
+	overhead := got.Costs["GlacierObjectOverhead"] + got.Costs["GlacierS3ObjectOverhead"]
+	if want := objects * perObject; !almostEqual(overhead, want) {
+		t.Errorf("overhead cost = %v, want %v", overhead, want)
+	}
+	// with objects this small the overhead costs far more than the data, pricing bytes alone would miss it
+	if data := got.Costs["GlacierStorage"]; overhead < 10*data {
+		t.Errorf("overhead cost %v is not well above the data cost %v", overhead, data)
+	}
+	if want := got.Costs["GlacierStorage"] + overhead; !almostEqual(got.TotalCost, want) {
+		t.Errorf("TotalCost = %v, want %v", got.TotalCost, want)
+	}
+}
+
+// fetchMetricsSerially ... fetchMetrics as before the object count ran alongside the sizes
+func fetchMetricsSerially(ctx context.Context, cwSvc cloudwatchAPI, bucket Bucket, days int) {
+	getNumberOfObjects(ctx, cwSvc, bucket)
+	for _, storageType := range bucket.sizeTypes() {
+		getBucketSizeBytes(ctx, cwSvc, bucket, storageType, days)
+	}
+}
+
+// benchmarkFetch ... one bucket's per type metrics against a cloudwatch answering each call in 1ms
+func benchmarkFetch(b *testing.B, fetch func(ctx context.Context, cwSvc cloudwatchAPI, bucket Bucket)) {
+	cw, buckets := fixture(1)
+	cw.latency = time.Millisecond
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fetch(context.Background(), cw, buckets[0])
+	}
+}
+
+func BenchmarkFetchMetricsSerially(b *testing.B) {
+	benchmarkFetch(b, func(ctx context.Context, cwSvc cloudwatchAPI, bucket Bucket) {
+		fetchMetricsSerially(ctx, cwSvc, bucket, sizeWindowDays)
+	})
+}
+
+func BenchmarkFetchMetrics(b *testing.B) {
+	benchmarkFetch(b, func(ctx context.Context, cwSvc cloudwatchAPI, bucket Bucket) {
+		fetchMetrics(ctx, cwSvc, bucket, sizeWindowDays, map[string]int{})
+	})
+}
+
+func BenchmarkFetchMetricsParallelSizes(b *testing.B) {
+	defer func(saved bool) { parallelSizes = saved }(parallelSizes)
+	parallelSizes = true
+	benchmarkFetch(b, func(ctx context.Context, cwSvc cloudwatchAPI, bucket Bucket) {
+		fetchMetrics(ctx, cwSvc, bucket, sizeWindowDays, map[string]int{})
+	})
+}
+
+func TestFetchMetricsParallelSizes(t *testing.T) {
+	cw, buckets := fixture(1)
+	wantCount, wantSizes, wantPrev, err := fetchMetrics(context.Background(), cw, buckets[0], sizeWindowDays, map[string]int{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved bool) { parallelSizes = saved }(parallelSizes)
+	parallelSizes = true
+	count, sizeBytes, prevBytes, err := fetchMetrics(context.Background(), cw, buckets[0], sizeWindowDays, map[string]int{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != wantCount || !reflect.DeepEqual(sizeBytes, wantSizes) || !reflect.DeepEqual(prevBytes, wantPrev) {
+		t.Errorf("-parallel-sizes fetched %v %v %v, want %v %v %v", count, sizeBytes, prevBytes, wantCount, wantSizes, wantPrev)
+	}
+}
+
+func TestScanTimeoutMarked(t *testing.T) {
+	defer func(saved time.Duration) { timeoutPerBucket = saved }(timeoutPerBucket)
+	timeoutPerBucket = 20 * time.Millisecond
+	// every call blocks far past the timeout until the bucket's context is cancelled
+	cw, buckets := fixture(1)
+	cw.latency = time.Minute
+	start := time.Now()
+	got := scanAll(cw, buckets)[buckets[0].Name]
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("scan took %s, the timeout did not stop it", elapsed)
+	}
+	if aerr, ok := got.Err.(awserr.Error); !ok || aerr.Code() != timeoutCode {
+		t.Fatalf("Err = %v, want a %s error", got.Err, timeoutCode)
+	}
+
+	finished := Bucket{Name: "finished", Region: defaultRegion}
+	for name, reporter := range map[string]func(w *stickyWriter) Reporter{
+		"table":    func(w *stickyWriter) Reporter { return &tableReporter{w: w} },
+		"markdown": func(w *stickyWriter) Reporter { return &markdownReporter{w: w} },
+	} {
+		var buf bytes.Buffer
+		r := reporter(&stickyWriter{w: &buf})
+		if err := r.WriteBucket(got); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.WriteBucket(finished); err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if strings.Contains(line, got.Name) && !strings.Contains(line, "[timed out]") {
+				t.Errorf("%s row of the timed out bucket has no marker: %q", name, line)
+			}
+			if strings.Contains(line, finished.Name) && strings.Contains(line, "[timed out]") {
+				t.Errorf("%s row of a finished bucket is marked: %q", name, line)
+			}
+		}
+		if !strings.Contains(buf.String(), finished.Name) {
+			t.Errorf("%s rows %q lack the finished bucket", name, buf.String())
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scanCache ... results of the latest scan reused by requests for -serve-ttl,
+// requests arriving during a scan wait for it instead of starting another
+type scanCache struct {
+	// targets ... buckets of each profile to scan, listed again by every scan
+	targets func() []target
+
+	mu      sync.Mutex
+	at      time.Time
+	buckets []Bucket
+	totals  Totals
+}
+
+// get ... cached buckets in report order and their totals, scanning again once they are older than -serve-ttl,
+// a scan of more buckets than -max-buckets without -yes is refused
+func (c *scanCache) get(ctx context.Context) ([]Bucket, Totals, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.buckets != nil && time.Since(c.at) < serveTTL {
+		return c.buckets, c.totals, nil
+	}
+	targets := c.targets()
+	if err := maxBucketsError(targets); err != nil {
+		return nil, Totals{}, err
+	}
+	resetScanState()
+	var totals Totals
+	buckets := []Bucket{}
+	scanTargets(ctx, targets, func(bucket Bucket) {
+		totals.Add(bucket)
+		buckets = append(buckets, bucket)
+	})
+	if ctx.Err() != nil {
+		return nil, Totals{}, ctx.Err()
+	}
+	sortBuckets(buckets)
+	c.at, c.buckets, c.totals = time.Now(), buckets, totals
+	return buckets, totals, nil
+}
+
+// resetScanState ... forget what earlier scans of the process learned, the -discover-types listings
+// and the retries taken from -retry-budget, so each rescan sees new buckets and storage types and may retry again
+func resetScanState() {
+	discovered.reset()
+	resetRetryBudget()
+}
+
+// serve ... answer /report in the -o format and /metrics in the prometheus text format until ctx is canceled,
+// each request scans unless the cached results are younger than -serve-ttl
+func serve(ctx context.Context, addr string) error {
+	cache := &scanCache{targets: func() []target { return dedupTargets(prepareTargets(profileNames())) }}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/report", func(w http.ResponseWriter, r *http.Request) {
+		buckets, totals, err := cache.get(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		contentType := "text/plain; charset=utf-8"
+		if jsonOutput() {
+			contentType = "application/json"
+		}
+		w.Header().Set("Content-Type", contentType)
+		reporter := newReporter(w)
+		for _, bucket := range aggregateSmall(buckets) {
+			if err := reporter.WriteBucket(bucket); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return
+			}
+		}
+		if err := reporter.WriteTotals(totals); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		buckets, totals, err := cache.get(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writePrometheus(w, buckets, totals)
+	})
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	fmt.Fprintf(os.Stderr, "serving /report and /metrics on %s\n", addr)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return fmt.Errorf("unable to serve on %s: %v", addr, err)
+	}
+	return nil
+}
+
+// writePrometheus ... gauges of each bucket and of the totals in the prometheus text format
+func writePrometheus(w io.Writer, buckets []Bucket, totals Totals) {
+	gauges := []struct {
+		name  string
+		help  string
+		value func(Bucket) float64
+	}{
+		{"s3usage_bucket_objects", "Number of objects of the bucket.", func(b Bucket) float64 { return b.NumberOfObjects }},
+		{"s3usage_bucket_size_bytes", "Size of the bucket in bytes.", func(b Bucket) float64 { return b.TotalSize * sizeDivisor() }},
+		{"s3usage_bucket_cost_usd", "Estimated monthly storage charge of the bucket in USD.", func(b Bucket) float64 { return b.TotalCost }},
+	}
+	for _, gauge := range gauges {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", gauge.name, gauge.help, gauge.name)
+		for _, bucket := range buckets {
+			fmt.Fprintf(w, "%s{bucket=\"%s\",profile=\"%s\",region=\"%s\"} %g\n", gauge.name,
+				promLabel(bucket.Name), promLabel(bucket.Profile), promLabel(bucket.Region), gauge.value(bucket))
+		}
+	}
+	fmt.Fprintf(w, "# HELP s3usage_total_cost_usd Estimated monthly storage charge of all buckets in USD.\n# TYPE s3usage_total_cost_usd gauge\ns3usage_total_cost_usd %g\n", totals.TotalCost)
+	fmt.Fprintf(w, "# HELP s3usage_total_size_bytes Size of all buckets in bytes.\n# TYPE s3usage_total_size_bytes gauge\ns3usage_total_size_bytes %g\n", totals.TotalSize*sizeDivisor())
+}
+
+// promLabel ... value escaped for a prometheus label
+func promLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestResetScanState(t *testing.T) {
+	defer resetScanState()
+	cw := &fakeCloudWatch{series: map[string][]float64{
+		fakeKey("old", "BucketSizeBytes", "StandardStorage"): {binaryGB},
+	}}
+	later := Bucket{Name: "later", Profile: "default", Region: defaultRegion}
+	types, err := discovered.storageTypes(context.Background(), cw, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(types) != 0 {
+		t.Fatalf("storage types of a bucket without metrics = %v, want none", types)
+	}
+
+	// the bucket is created and starts using Glacier after the first scan
+	cw.series[fakeKey("later", "BucketSizeBytes", "GlacierStorage")] = []float64{binaryGB}
+	atomic.StoreInt64(&retriesTaken, 100)
+	resetScanState()
+	if types, _ = discovered.storageTypes(context.Background(), cw, later); !reflect.DeepEqual(types, []string{"GlacierStorage"}) {
+		t.Errorf("storage types after reset = %v, want [GlacierStorage]", types)
+	}
+	if taken := atomic.LoadInt64(&retriesTaken); taken != 0 {
+		t.Errorf("retries taken after reset = %d, want 0", taken)
+	}
+}
+
+func TestScanCacheMaxBuckets(t *testing.T) {
+	savedMax, savedYes := maxBuckets, assumeYes
+	defer func() { maxBuckets, assumeYes = savedMax, savedYes }()
+	maxBuckets, assumeYes = 2, false
+	listed := 0
+	cache := &scanCache{targets: func() []target {
+		listed++
+		return []target{{buckets: make([]Bucket, 3)}}
+	}}
+	buckets, _, err := cache.get(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "-max-buckets") {
+		t.Fatalf("get() = %v, %v, want the -max-buckets error", buckets, err)
+	}
+	// a refused scan is not cached, the next request lists the buckets again
+	if _, _, err := cache.get(context.Background()); err == nil || listed != 2 {
+		t.Errorf("second get() = %v after %d listings, want the error after 2", err, listed)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqliteCommand ... sqlite3 shell the -sqlite statements are run by, no sqlite driver is among the dependencies
+const sqliteCommand = "sqlite3"
+
+// sqliteSchema ... table of the per bucket figures of each run, created when missing,
+// sizes and costs are json objects by storage type for sqlite's json functions
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS usage (
+  run_at TEXT NOT NULL,
+  bucket_name TEXT NOT NULL,
+  profile TEXT NOT NULL,
+  region TEXT NOT NULL,
+  number_of_objects REAL NOT NULL,
+  total_size REAL NOT NULL,
+  total_cost REAL NOT NULL,
+  sizes TEXT NOT NULL,
+  costs TEXT NOT NULL,
+  PRIMARY KEY (run_at, bucket_name, region)
+);
+CREATE INDEX IF NOT EXISTS usage_bucket ON usage (bucket_name, run_at);
+`
+
+// checkSQLite ... error unless the sqlite3 shell -sqlite needs can be found
+func checkSQLite() error {
+	if _, err := exec.LookPath(sqliteCommand); err != nil {
+		return fmt.Errorf("-sqlite needs the %s command: %v", sqliteCommand, err)
+	}
+	return nil
+}
+
+// writeSQLite ... append each bucket's figures to the usage table of -sqlite with the run time runAt,
+// buckets whose metrics could not be fetched are left out rather than written as zero
+func writeSQLite(buckets []Bucket, runAt time.Time) error {
+	script, err := sqliteScript(buckets, runAt)
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command(sqliteCommand, "-bail", sqliteFile)
+	cmd.Stdin = strings.NewReader(script)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("unable to write to %s: %v %s", sqliteFile, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// sqliteScript ... statements creating the schema and inserting the buckets in one transaction
+func sqliteScript(buckets []Bucket, runAt time.Time) (string, error) {
+	var b strings.Builder
+	b.WriteString(sqliteSchema)
+	b.WriteString("BEGIN;\n")
+	at := sqlQuote(runAt.UTC().Format(time.RFC3339))
+	for _, bucket := range buckets {
+		if bucket.Err != nil {
+			fmt.Fprintf(os.Stderr, "not writing bucket %s to %s: %s\n", bucket.Name, sqliteFile, errorSummary(bucket.Err))
+			continue
+		}
+		sizes, err := json.Marshal(bucket.Sizes)
+		if err != nil {
+			return "", fmt.Errorf("unable to marshal sizes of bucket %s: %v", bucket.Name, err)
+		}
+		costs, err := json.Marshal(bucket.Costs)
+		if err != nil {
+			return "", fmt.Errorf("unable to marshal costs of bucket %s: %v", bucket.Name, err)
+		}
+		fmt.Fprintf(&b, "INSERT INTO usage VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %s);\n",
+			at, sqlQuote(bucket.Name), sqlQuote(bucket.Profile), sqlQuote(bucket.Region),
+			sqlReal(bucket.NumberOfObjects), sqlReal(bucket.TotalSize), sqlReal(bucket.TotalCost),
+			sqlQuote(string(sizes)), sqlQuote(string(costs)))
+	}
+	b.WriteString("COMMIT;\n")
+	return b.String(), nil
+}
+
+// sqlQuote ... s as an sql string literal
+func sqlQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// sqlReal ... v as an sql numeric literal
+func sqlReal(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteSQLite(t *testing.T) {
+	if err := checkSQLite(); err != nil {
+		t.Skip(err)
+	}
+	dir, err := ioutil.TempDir("", "s3usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(saved string) { sqliteFile = saved }(sqliteFile)
+	sqliteFile = filepath.Join(dir, "usage.db")
+
+	buckets := []Bucket{
+		{Name: "bucket", Profile: "it's", Region: defaultRegion, NumberOfObjects: 10, TotalSize: 1.5, TotalCost: 0.0375,
+			Sizes: map[string]float64{"StandardStorage": 1.5}, Costs: map[string]float64{"StandardStorage": 0.0375}},
+		{Name: "failed", Region: defaultRegion, Err: errors.New("AccessDenied")},
+	}
+	// the schema is created by the first run and rows of each run are appended
+	day := time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC)
+	for _, runAt := range []time.Time{day, day.Add(24 * time.Hour)} {
+		if err := writeSQLite(buckets, runAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, err := exec.Command(sqliteCommand, sqliteFile,
+		"SELECT run_at, profile, total_cost, json_extract(sizes, '$.StandardStorage') FROM usage ORDER BY run_at").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "2020-04-01T00:00:00Z|it's|0.0375|1.5\n2020-04-02T00:00:00Z|it's|0.0375|1.5"
+	if got := strings.TrimSpace(string(out)); got != want {
+		t.Errorf("usage rows = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// loadStorageLens ... StorageBytes of each bucket on the latest report date of an s3 storage lens csv export,
+// summed over storage classes
+func loadStorageLens(path string) (map[string]float64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read storage lens export: %v", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse storage lens export %s: %v", path, err)
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[name] = i
+	}
+	for _, name := range []string{"report_date", "record_type", "bucket_name", "metric_name", "metric_value"} {
+		if _, ok := col[name]; !ok {
+			return nil, fmt.Errorf("storage lens export %s has no %s column", path, name)
+		}
+	}
+
+	// bytes of each bucket, reset whenever a later report date shows up
+	latest := ""
+	sizes := map[string]float64{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse storage lens export %s: %v", path, err)
+		}
+		if record[col["record_type"]] != "BUCKET" || record[col["metric_name"]] != "StorageBytes" {
+			continue
+		}
+		date := record[col["report_date"]]
+		if date < latest {
+			continue
+		}
+		if date > latest {
+			latest = date
+			sizes = map[string]float64{}
+		}
+		value, err := strconv.ParseFloat(record[col["metric_value"]], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metric_value %q in storage lens export %s", record[col["metric_value"]], path)
+		}
+		sizes[record[col["bucket_name"]]] += value
+	}
+	return sizes, nil
+}
+
+// printStorageLensDiff ... warn of buckets whose size differs from storage lens by more than -storage-lens-tolerance percent
+// buckets missing from the export or whose metrics could not be fetched are not compared
+func printStorageLensDiff(buckets []Bucket, lens map[string]float64) {
+	for _, bucket := range buckets {
+		lensBytes, ok := lens[bucket.Name]
+		if !ok || bucket.Err != nil {
+			continue
+		}
+		cwBytes := bucket.TotalSize * sizeDivisor()
+		if cwBytes == lensBytes {
+			continue
+		}
+		diff := 100.0
+		if lensBytes != 0 {
+			diff = (cwBytes - lensBytes) / lensBytes * 100
+		}
+		if math.Abs(diff) <= storageLensTolerance {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "bucket %s differs from storage lens by %+.1f%%: cloudwatch %s %s, storage lens %s %s\n",
+			bucket.Name,
+			diff,
+			formatSize(bucket.TotalSize),
+			sizeLabel(),
+			formatSize(lensBytes/sizeDivisor()),
+			sizeLabel())
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// callStats ... cloudwatch requests of the run, recorded by every client once a request completes
+var callStats = struct {
+	mu         sync.Mutex
+	calls      int
+	failed     int
+	retries    int
+	operations map[string]int
+}{operations: map[string]int{}}
+
+// recordCall ... request.Handlers.Complete handler counting r, its retries and whether it failed
+func recordCall(r *request.Request) {
+	callStats.mu.Lock()
+	defer callStats.mu.Unlock()
+	callStats.calls++
+	callStats.retries += r.RetryCount
+	if r.Error != nil {
+		callStats.failed++
+	}
+	callStats.operations[r.Operation.Name]++
+}
+
+// printRunSummary ... one line of wall time, buckets and cloudwatch calls to stderr unless -quiet,
+// calls of each operation are added with -v or -debug
+func printRunSummary(buckets int) {
+	if quiet {
+		return
+	}
+	callStats.mu.Lock()
+	defer callStats.mu.Unlock()
+	fmt.Fprintf(os.Stderr, "scanned %d buckets in %s with %d cloudwatch calls, %d failed, %d retries\n",
+		buckets, time.Since(startedAt).Round(time.Millisecond), callStats.calls, callStats.failed, callStats.retries)
+	if !verbose && !debug {
+		return
+	}
+	operations := []string{}
+	for operation := range callStats.operations {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	for _, operation := range operations {
+		fmt.Fprintf(os.Stderr, "  %s: %d calls\n", operation, callStats.operations[operation])
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"text/template"
+)
+
+var (
+	bucketTemplate *template.Template
+	footerTemplate *template.Template
+)
+
+// templateFuncs ... helpers to format sizes and costs as the table does
+var templateFuncs = template.FuncMap{
+	"size": formatSize,
+	"cost": formatCost,
+}
+
+// parseTemplates ... parse -template and -template-footer
+func parseTemplates() error {
+	var err error
+	if bucketTemplate, err = template.New("template").Funcs(templateFuncs).Parse(templateText); err != nil {
+		return fmt.Errorf("invalid -template: %v", err)
+	}
+	if footerText == "" {
+		return nil
+	}
+	if footerTemplate, err = template.New("template-footer").Funcs(templateFuncs).Parse(footerText); err != nil {
+		return fmt.Errorf("invalid -template-footer: %v", err)
+	}
+	return nil
+}